@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// Linha do JSONL escrito pelo analyze com -output stdout
+type analysisRecord struct {
+	URI        string        `json:"post_uri"`
+	Query      string        `json:"query"`
+	Author     string        `json:"author,omitempty"`
+	Content    string        `json:"content"`
+	CreatedAt  string        `json:"created_at,omitempty"`
+	RawOutput  string        `json:"rawOutput"`
+	Analysis   []Medication  `json:"analysis"`
+	Mentions   []drugMention `json:"drug_mentions,omitempty"`
+	ParseError string        `json:"parse_error,omitempty"`
+}
+
+// Aceita texto puro (um post por linha), JSONL ou CSV no formato do -source file
+func decodeAnalyzeRecord(data []byte) (Post, bool, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		wrapped, err := json.Marshal(fileRecord{Text: string(trimmed)})
+		if err != nil {
+			return Post{}, false, err
+		}
+		data = wrapped
+	}
+	post, err := decodeFileRecord(data)
+	return post, err == nil && post.Record.Text != "", err
+}
+
+// Roda o mesmo prompt/provedor sobre datasets locais (arquivos ou stdin), sem
+// acessar o BlueSky. Com -output mongo grava como os outros comandos
+func runAnalyze(ctx context.Context, files []string, start time.Time) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	src := &fileSource{name: "analyze", files: files, decode: decodeAnalyzeRecord}
+
+	// A query vem do registro ou do texto; posts sem medicamento da lista tambem sao analisados
+	queryFor := func(post Post) (string, bool) {
+		query, _ := matchedQuery(post)
+		return query, true
+	}
+
+	var p *pipeline
+	var err error
+	switch *outputFlag {
+	case "stdout":
+		p = newPipeline(ctx, *workersFlag, *batchSizeFlag, os.Stdout)
+		err = src.Stream(ctx, &checkpoint{}, func(posts []Post) error {
+			for _, post := range posts {
+				query, _ := queryFor(post)
+				if err := p.submit(post, query, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "mongo":
+		initDB()
+		p = newPipeline(ctx, *workersFlag, *batchSizeFlag, nil)
+		cp := startCheckpoint("analyze", "analyze")
+		err = src.Stream(ctx, cp, func(posts []Post) error {
+			return submitPage(p, cp, posts, queryFor, false)
+		})
+	default:
+		log.Fatalf("unknown -output %q", *outputFlag)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Analyze stopped: %v", err)
+	}
+	p.close()
+	log.Print(p.summary(start))
+}
+
+// Escrita do lote em JSONL, na ordem de entrada
+func (p *pipeline) writeJSONL(batch []postResult) {
+	for _, r := range batch {
+		record := analysisRecord{
+			URI:       r.post.URI,
+			Query:     r.query,
+			Author:    r.post.Author.Handle,
+			Content:   r.post.Record.Text,
+			CreatedAt: r.post.Record.CreatedAt,
+			RawOutput: r.answer,
+			Analysis:  r.analysis,
+			Mentions:  r.mentions,
+
+			ParseError: r.parseError,
+		}
+		if err := p.out.Encode(record); err != nil {
+			log.Printf("Output error: %v", err)
+		}
+		p.release(r.post.URI)
+		p.written += 1
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+)
+
+// Status da ADR no post: relato real, negado ("nao me deu sono"),
+// hipotetico ("medo de engordar") ou incerto ("acho que e da sertralina")
+const (
+	assertionAffirmed     = "affirmed"
+	assertionNegated      = "negated"
+	assertionHypothetical = "hypothetical"
+	assertionUncertain    = "uncertain"
+)
+
+// Gatilhos por idioma, ja sem acento e em minusculas. "no" e gatilho em
+// es/en mas em pt e "em + o", por isso o lexico depende do idioma do post
+var assertionCues = map[string]map[string][]string{
+	"pt": {
+		assertionNegated:      {"nao", "nunca", "nem", "sem", "nenhum", "nenhuma", "jamais", "zero"},
+		assertionHypothetical: {"medo de", "receio de", "se eu", "caso eu", "vai que", "e se", "pode causar", "pode dar", "podem causar", "risco de", "para evitar", "pra evitar", "evitar"},
+		assertionUncertain:    {"acho que", "talvez", "sera que", "nao sei se", "parece que", "pode ser", "provavelmente", "deve ser"},
+	},
+	"es": {
+		assertionNegated:      {"no", "nunca", "ni", "sin", "ningun", "ninguna", "jamas"},
+		assertionHypothetical: {"miedo de", "miedo a", "si me", "por si", "puede causar", "pueden causar", "riesgo de", "para evitar"},
+		assertionUncertain:    {"creo que", "quizas", "quiza", "tal vez", "no se si", "parece que", "puede ser", "probablemente"},
+	},
+	"en": {
+		assertionNegated:      {"no", "not", "never", "without", "none", "didn t", "doesn t", "don t", "haven t", "hasn t", "wasn t", "isn t"},
+		assertionHypothetical: {"afraid of", "scared of", "worried about", "if i", "in case", "could cause", "may cause", "might cause", "risk of", "to avoid"},
+		assertionUncertain:    {"i think", "maybe", "perhaps", "not sure", "probably", "seems like", "might be", "could be"},
+	},
+}
+
+// Palavras antes da citacao da ADR que ainda contam como contexto
+const assertionWindow = 6
+
+// Sem idioma no post, gatilhos mais curtos que isso ("no", "ni") sao ignorados:
+// sao palavras comuns em outro idioma e sozinhos nao derrubam a resposta do LLM
+const untaggedMinCueLength = 3
+
+// Conjuncoes que encerram o alcance de um gatilho ("nao me deu sono mas tive nausea")
+var assertionTerminators = map[string]bool{
+	"mas": true, "porem": true, "contudo": true, "entretanto": true,
+	"pero": true, "sino": true, "aunque": true,
+	"but": true, "however": true, "although": true,
+}
+
+func normalizeAssertion(assertion string) string {
+	switch strings.ToLower(strings.TrimSpace(assertion)) {
+	case "", assertionAffirmed, "positive", "present":
+		return assertionAffirmed
+	case assertionNegated, "negative", "absent", "denied":
+		return assertionNegated
+	case assertionHypothetical, "conditional", "possible", "feared":
+		return assertionHypothetical
+	case assertionUncertain, "speculative", "speculation", "unsure":
+		return assertionUncertain
+	}
+	return assertionUncertain
+}
+
+// Gatilho mais longo encontrado no contexto (status, gatilho); "" se nao houver
+func matchAssertionCue(context string, langs []string) (string, string) {
+	words := " " + strings.Join(strings.Fields(context), " ") + " "
+	var lexicons []map[string][]string
+	for _, lang := range langs {
+		if cues, ok := assertionCues[strings.ToLower(strings.SplitN(lang, "-", 2)[0])]; ok {
+			lexicons = append(lexicons, cues)
+		}
+	}
+	// Idioma desconhecido (Reddit, texto do analyze): todos, em ordem fixa para o
+	// resultado ser estavel, sem os gatilhos curtos ambiguos
+	if len(lexicons) == 0 {
+		for _, lang := range []string{"pt", "es", "en"} {
+			cues := make(map[string][]string)
+			for class, list := range assertionCues[lang] {
+				for _, c := range list {
+					if len(c) >= untaggedMinCueLength {
+						cues[class] = append(cues[class], c)
+					}
+				}
+			}
+			lexicons = append(lexicons, cues)
+		}
+	}
+
+	status, cue := "", ""
+	for _, cues := range lexicons {
+		if s, c := longestCue(words, cues, []string{assertionUncertain, assertionHypothetical, assertionNegated}); len(c) > len(cue) {
+			status, cue = s, c
+		}
+	}
+	return status, cue
+}
+
+// Gatilho mais longo de cues presente em words (palavras normalizadas entre
+// espacos); no empate vale a ordem de classes
+func longestCue(words string, cues map[string][]string, classes []string) (string, string) {
+	class, cue := "", ""
+	for _, candidate := range classes {
+		for _, c := range cues[candidate] {
+			if len(c) > len(cue) && strings.Contains(words, " "+c+" ") {
+				class, cue = candidate, c
+			}
+		}
+	}
+	return class, cue
+}
+
+// Contexto da ADR: so as palavras antes da citacao, na mesma frase. A propria
+// citacao fica de fora: "nao consigo dormir" e "sem apetite" sao a reacao, nao
+// a negacao dela. Sem citacao localizada no post nao ha contexto
+func assertionContext(text string, evidence *evidenceSpan) string {
+	if !evidence.Grounded {
+		return ""
+	}
+	before := text[:evidence.Start]
+	if i := strings.LastIndexAny(before, ".!?\n;"); i >= 0 {
+		before = before[i+1:]
+	}
+	normBefore, _, _ := normalizeForMatch(before)
+	words := strings.Fields(normBefore)
+	for k := len(words) - 1; k >= 0; k-- {
+		if assertionTerminators[words[k]] {
+			words = words[k+1:]
+			break
+		}
+	}
+	if len(words) > assertionWindow {
+		words = words[len(words)-assertionWindow:]
+	}
+	return strings.Join(words, " ")
+}
+
+// Combina o status dado pelo LLM com o lexico: o LLM manda quando diz que a
+// ADR nao e afirmada; se ele a afirma (ou nao diz), um gatilho logo antes da
+// evidencia rebaixa o status. Roda depois do groundAnalysis (usa os offsets)
+func assessAssertions(analysis []Medication, text string, langs []string) []Medication {
+	for i := range analysis {
+		for j := range analysis[i].ADRs {
+			adr := &analysis[i].ADRs[j]
+			adr.Assertion = normalizeAssertion(adr.Assertion)
+			if adr.Assertion != assertionAffirmed || adr.Evidence == nil {
+				continue
+			}
+			if status, cue := matchAssertionCue(assertionContext(text, adr.Evidence), langs); status != "" {
+				adr.Assertion, adr.AssertionCue = status, cue
+			}
+		}
+	}
+	return analysis
+}
+
+// Status que entram nos agregados (medications.adrs, vocabulario), do -count-assertions
+func countedAssertion(assertion string) bool {
+	if assertion == "" {
+		assertion = assertionAffirmed
+	}
+	for _, status := range strings.Split(*countAssertionsFlag, ",") {
+		if strings.TrimSpace(status) == assertion {
+			return true
+		}
+	}
+	return false
+}
+
+// ADR real e com status contado nos agregados
+func (m adrMention) counted() bool {
+	return m.isADR() && countedAssertion(m.Assertion)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestAssessAssertions(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		quote     string
+		llm       string
+		langs     []string
+		assertion string
+	}{
+		{"negacao dentro da citacao", "Fluoxetina: não consigo dormir", "não consigo dormir", "affirmed", []string{"pt"}, assertionAffirmed},
+		{"sem dentro da citacao", "a sertralina me deixou sem apetite", "me deixou sem apetite", "affirmed", []string{"pt"}, assertionAffirmed},
+		{"nunca dentro da citacao", "desde a venlafaxina nunca mais dormi bem", "nunca mais dormi bem", "", []string{"pt"}, assertionAffirmed},
+		{"negacao antes da citacao", "fluoxetina não me deu sono", "sono", "affirmed", []string{"pt"}, assertionNegated},
+		{"hipotetico antes da citacao", "tenho medo de engordar com sertralina", "engordar", "affirmed", nil, assertionHypothetical},
+		{"incerto antes da citacao", "acho que a sertralina me deixou tonta", "me deixou tonta", "affirmed", []string{"pt"}, assertionUncertain},
+		{"conjuncao encerra o gatilho", "não me deu sono mas me deu náusea", "náusea", "affirmed", []string{"pt"}, assertionAffirmed},
+		{"negacao em ingles", "I didn't get any nausea", "nausea", "affirmed", []string{"en"}, assertionNegated},
+		{"no em portugues nao nega", "tomei no dia e tive dor de cabeça", "dor de cabeça", "affirmed", []string{"pt"}, assertionAffirmed},
+		{"no sem idioma nao nega", "tomei fluoxetina no dia e tive dor de cabeça", "dor de cabeça", "affirmed", nil, assertionAffirmed},
+		{"no domingo sem idioma", "comecei a sertralina no domingo e fiquei com muito sono", "muito sono", "affirmed", nil, assertionAffirmed},
+		{"nao sem idioma nega", "a fluoxetina não me deu sono", "sono", "affirmed", nil, assertionNegated},
+		{"no em espanhol nega", "la fluoxetina no me dio sueño", "sueño", "affirmed", []string{"es"}, assertionNegated},
+		{"LLM manda quando nao afirma", "me deu muito sono", "muito sono", "negated", []string{"pt"}, assertionNegated},
+		{"citacao fora do post", "fluoxetina não me deu sono", "insônia terrível", "affirmed", []string{"pt"}, assertionAffirmed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := []Medication{{Name: "X", ADRs: []adrMention{{
+				Verbatim:  "ADR",
+				Relation:  relationAdverse,
+				Assertion: tt.llm,
+				Evidence:  &evidenceSpan{Quote: tt.quote},
+			}}}}
+			analysis = assessAssertions(groundAnalysis(analysis, tt.text), tt.text, tt.langs)
+			if got := analysis[0].ADRs[0].Assertion; got != tt.assertion {
+				t.Errorf("assertion = %q (cue %q), want %q", got, analysis[0].ADRs[0].AssertionCue, tt.assertion)
+			}
+		})
+	}
+}
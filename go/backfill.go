@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Janela since/until ja coletada por completo
+type backfillWindow struct {
+	Query     string    `bson:"query"`
+	Since     time.Time `bson:"since"`
+	Until     time.Time `bson:"until"`
+	Posts     int       `bson:"posts"`
+	Truncated bool      `bson:"truncated"`
+}
+
+func parseDateFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339, got %q", value)
+	}
+	return t.UTC(), nil
+}
+
+// Percorre [since, until) do mais novo para o mais antigo em fatias adaptativas.
+// Quando o cursor acaba antes de cobrir a fatia (limite do searchPosts), a parte
+// coberta e registrada e a fatia seguinte e reduzida pela metade. Uma fatia
+// que bate no limite sem nenhum post dentro dela nao e registrada: e dividida
+// ou, ja no -min-window, fica para a proxima execucao.
+func runBackfill(p *pipeline, session *blueskySession, query string, since, until time.Time) {
+	cp := startCheckpoint(query, "backfill")
+	if cp.Done && !cp.Since.After(since) && !cp.Until.Before(until) {
+		log.Printf("Skipping %s: backfill already completed", query)
+		return
+	}
+
+	completed, err := loadBackfillWindows(query)
+	if err != nil {
+		log.Printf("Backfill windows lookup error: %v", err)
+	}
+
+	slice := *windowFlag
+	end := until
+	resuming := *resumeFlag && !cp.Done && !cp.Until.IsZero() && skipCompleted(completed, cp.Until).Equal(cp.Until)
+	if resuming {
+		// Retoma a janela interrompida com o mesmo cursor
+		slice, end = cp.Slice, cp.Until
+	}
+	cp.Done = false
+	uncovered := false
+
+	for end.After(since) {
+		start := cp.Since
+		if !resuming {
+			end = skipCompleted(completed, end)
+			if !end.After(since) {
+				break
+			}
+
+			start = end.Add(-slice)
+			if start.Before(since) {
+				start = since
+			}
+			cp.Since, cp.Until, cp.Slice = start, end, slice
+			cp.Cursor, cp.Retrieved, cp.Oldest = "", 0, time.Time{}
+		}
+		resuming = false
+
+		log.Printf("Backfill %s: %s -> %s (slice %s)", query, start.Format(time.RFC3339), end.Format(time.RFC3339), slice)
+		stats, err := collectPages(p, session, searchParams{
+			Query: query,
+			Sort:  "latest",
+			Since: start,
+			Until: end,
+		}, maxResults, cp)
+		if err != nil {
+			// Janela fica sem registro; o checkpoint permite retomar com --resume
+			log.Printf("Backfill %s aborted: %v", query, err)
+			return
+		}
+
+		truncated := !stats.Exhausted || stats.LastPageFull
+		if truncated && (stats.Oldest.IsZero() || !stats.Oldest.Before(end)) {
+			// Nada garantido dentro da fatia
+			if slice > *minWindowFlag {
+				if slice /= 2; slice < *minWindowFlag {
+					slice = *minWindowFlag
+				}
+				continue
+			}
+			log.Printf("Backfill %s: %s -> %s hit the result limit without posts inside it, left for the next run",
+				query, start.Format(time.RFC3339), end.Format(time.RFC3339))
+			uncovered = true
+			end = start
+			continue
+		}
+		if truncated && stats.Oldest.After(start) && stats.Oldest.Before(end) {
+			// So o trecho [mais antigo visto, end) esta garantido
+			window := backfillWindow{Query: query, Since: stats.Oldest, Until: end, Posts: stats.Retrieved, Truncated: true}
+			p.after(func() { saveBackfillWindow(window) })
+			completed = append(completed, window)
+
+			end = stats.Oldest
+			if slice /= 2; slice < *minWindowFlag {
+				slice = *minWindowFlag
+			}
+			continue
+		}
+
+		window := backfillWindow{Query: query, Since: start, Until: end, Posts: stats.Retrieved, Truncated: truncated}
+		p.after(func() { saveBackfillWindow(window) })
+		completed = append(completed, window)
+
+		end = start
+		if !truncated && slice < *windowFlag {
+			if slice *= 2; slice > *windowFlag {
+				slice = *windowFlag
+			}
+		}
+	}
+
+	cp.Since, cp.Until, cp.Cursor, cp.Done = since, until, "", true
+	if uncovered {
+		// Sem janela para retomar: a proxima execucao recomeca pelas lacunas
+		cp.Since, cp.Until, cp.Done = time.Time{}, time.Time{}, false
+	}
+	snapshot := *cp
+	p.after(func() {
+		saveCheckpoint(&snapshot)
+		log.Printf("Backfill %s finished", query)
+	})
+}
+
+// Recua end enquanto ele estiver dentro de uma janela ja concluida
+func skipCompleted(completed []backfillWindow, end time.Time) time.Time {
+	for moved := true; moved; {
+		moved = false
+		for _, w := range completed {
+			if w.Since.Before(end) && !end.After(w.Until) {
+				end = w.Since
+				moved = true
+			}
+		}
+	}
+	return end
+}
+
+func loadBackfillWindows(query string) ([]backfillWindow, error) {
+	cur, err := backfillColl.Find(context.TODO(), bson.M{"query": query})
+	if err != nil {
+		return nil, err
+	}
+	var windows []backfillWindow
+	if err := cur.All(context.TODO(), &windows); err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+func saveBackfillWindow(w backfillWindow) {
+	filter := bson.M{"query": w.Query, "since": w.Since, "until": w.Until}
+	update := bson.M{
+		"$set": bson.M{
+			"posts":        w.Posts,
+			"truncated":    w.Truncated,
+			"completed_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+		},
+	}
+	_, err := backfillColl.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Backfill window save error: %v", err)
+	}
+}
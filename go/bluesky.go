@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// O accessJwt vale ~2h; renova antes disso em vez de criar sessao por query
+const blueskyTokenTTL = 90 * time.Minute
+
+var errUnauthorized = errors.New("access token rejected")
+
+// Fonte padrao: app.bsky.feed.searchPosts para buscas e Jetstream para o stream
+type blueskySource struct {
+	client      *rateLimitedClient
+	session     *blueskySession
+	incremental bool
+
+	// Corte do modo incremental por medicamento (nome canonico), calculado na
+	// primeira query dele: posts gravados pelas primeiras queries (nome generico)
+	// nao podem mover o corte das seguintes (marcas, variantes)
+	since map[string]time.Time
+}
+
+func newBlueskySource(incremental bool) *blueskySource {
+	client := newRateLimitedClient()
+	return &blueskySource{client: client, session: newBlueskySession(client), incremental: incremental, since: make(map[string]time.Time)}
+}
+
+func (b *blueskySource) Name() string { return "bluesky" }
+
+func (b *blueskySource) Search(ctx context.Context, query string, cp *checkpoint, page func([]Post) error) error {
+	params := searchParams{Query: query}
+	if b.incremental {
+		drug := canonicalName(query)
+		newest, ok := b.since[drug]
+		if !ok {
+			var err error
+			if newest, err = newestStoredPost(drug); err != nil {
+				log.Printf("Newest post lookup error: %v", err)
+			}
+			b.since[drug] = newest
+		}
+		params.Sort, params.Since = "latest", newest
+		log.Printf("Incremental %s: since %s", query, newest.Format(time.RFC3339))
+	}
+
+	_, err := searchPages(ctx, b.session, params, maxResults, cp, page)
+	return err
+}
+
+// Sessao do BlueSky reaproveitada entre queries; o createSession tem limite
+// proprio bem mais baixo que o das buscas
+type blueskySession struct {
+	client   *rateLimitedClient
+	token    string
+	obtained time.Time
+}
+
+func newBlueskySession(client *rateLimitedClient) *blueskySession {
+	return &blueskySession{client: client}
+}
+
+func (s *blueskySession) accessToken() (string, error) {
+	if s.token != "" && time.Since(s.obtained) < blueskyTokenTTL {
+		return s.token, nil
+	}
+	token, err := authenticate(s.client)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.obtained = token, time.Now()
+	return token, nil
+}
+
+// searchPosts com o token da sessao. Um 401 (token expirado antes do TTL ou
+// revogado) renova a sessao e repete a pagina uma vez
+func (s *blueskySession) search(ctx context.Context, params searchParams) (searchResult, error) {
+	for attempt := 1; ; attempt++ {
+		token, err := s.accessToken()
+		if err != nil {
+			return searchResult{}, err
+		}
+		result, err := searchPosts(ctx, s.client, token, params)
+		if !errors.Is(err, errUnauthorized) || attempt > 1 {
+			return result, err
+		}
+		log.Printf("BlueSky session expired, authenticating again")
+		s.token = ""
+	}
+}
+
+func (b *blueskySource) Stream(ctx context.Context, cp *checkpoint, page func([]Post) error) error {
+	return runJetstream(ctx, *jetstreamFlag, cp, page)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Progresso de uma query, salvo apos cada pagina para o --resume
+type checkpoint struct {
+	Query     string        `bson:"query"`
+	Mode      string        `bson:"mode"`
+	Cursor    string        `bson:"cursor"`
+	Since     time.Time     `bson:"since,omitempty"`
+	Until     time.Time     `bson:"until,omitempty"`
+	Slice     time.Duration `bson:"slice,omitempty"`
+	Oldest    time.Time     `bson:"oldest,omitempty"`
+	Retrieved int           `bson:"retrieved"`
+	Total     int           `bson:"total"`
+	Done      bool          `bson:"done"`
+	UpdatedAt time.Time     `bson:"updated_at"`
+}
+
+// Comeca do zero, ou continua de onde parou quando --resume
+func startCheckpoint(query string, mode string) *checkpoint {
+	if *resumeFlag {
+		var cp checkpoint
+		err := checkpointsColl.FindOne(context.TODO(), bson.M{"query": query, "mode": mode}).Decode(&cp)
+		if err == nil {
+			log.Printf("Resuming %s (%s): cursor=%v, Total=%d, done=%v", query, mode, cp.Cursor != "", cp.Total, cp.Done)
+			return &cp
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Checkpoint lookup error: %v", err)
+		}
+	}
+	return &checkpoint{Query: query, Mode: mode}
+}
+
+func saveCheckpoint(cp *checkpoint) {
+	cp.UpdatedAt = time.Now().UTC()
+	_, err := checkpointsColl.ReplaceOne(context.TODO(),
+		bson.M{"query": cp.Query, "mode": cp.Mode},
+		cp,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Checkpoint save error: %v", err)
+	}
+}
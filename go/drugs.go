@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Entrada do dicionario de medicamentos: ID canonico, codigo ATC e os nomes
+// (traducoes, marcas, erros comuns) que levam a ele
+type drugEntry struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	ATC      string   `json:"atc"`
+	Synonyms []string `json:"synonyms"`
+}
+
+var (
+	drugDictionary []drugEntry
+	// Nome (sem acento, minusculo) -> indice em drugDictionary
+	drugIndex map[string]int
+)
+
+func loadDrugDictionary(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []drugEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	drugDictionary, drugIndex = nil, make(map[string]int)
+	for _, entry := range entries {
+		if entry.ID == "" || entry.Name == "" {
+			log.Printf("Drug dictionary: skipping entry without id or name: %+v", entry)
+			continue
+		}
+		for _, name := range append([]string{entry.Name}, entry.Synonyms...) {
+			key := foldText(strings.TrimSpace(name))
+			if i, ok := drugIndex[key]; ok {
+				if i == len(drugDictionary) {
+					continue
+				}
+				log.Printf("Drug dictionary: %q is a name of both %s and %s, keeping %s", name, drugDictionary[i].ID, entry.ID, drugDictionary[i].ID)
+				continue
+			}
+			drugIndex[key] = len(drugDictionary)
+		}
+		drugDictionary = append(drugDictionary, entry)
+	}
+	log.Printf("Drug dictionary: %d drugs, %d names", len(drugDictionary), len(drugIndex))
+	return nil
+}
+
+// Entrada do dicionario para um nome; os nomes da watchlist valem pelo nome canonico
+func normalizeDrug(name string) (drugEntry, bool) {
+	if i, ok := drugIndex[foldText(strings.TrimSpace(name))]; ok {
+		return drugDictionary[i], true
+	}
+	if drug, ok := findDrug(name); ok {
+		if i, ok := drugIndex[foldText(drug.Name)]; ok {
+			return drugDictionary[i], true
+		}
+	}
+	return drugEntry{}, false
+}
+
+// Troca o nome devolvido pelo LLM pelo canonico, guardando o original em Verbatim
+func normalizeMedications(analysis []Medication) []Medication {
+	for i, med := range analysis {
+		entry, ok := normalizeDrug(med.Name)
+		if !ok {
+			continue
+		}
+		analysis[i].Verbatim = med.Name
+		analysis[i].Name = entry.Name
+		analysis[i].DrugID = entry.ID
+		analysis[i].ATC = entry.ATC
+	}
+	return analysis
+}
+
+// Junta os documentos de medications criados pelo nome antes da normalizacao
+// no documento do ID canonico, somando mencoes e ADRs (todas as listas e contagens)
+func normalizeStoredMedications() {
+	cur, err := medicationsColl.Find(context.TODO(), bson.M{"drug_id": bson.M{"$exists": false}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cur.Close(context.TODO())
+
+	merged, unknown := 0, 0
+	for cur.Next(context.TODO()) {
+		var doc struct {
+			ID             primitive.ObjectID  `bson:"_id"`
+			Name           string              `bson:"name"`
+			ADRs           []string            `bson:"adrs"`
+			MentionCount   int                 `bson:"mentionCount"`
+			FirstHandADRs  []string            `bson:"firstHandAdrs"`
+			FirstHandCount int                 `bson:"firstHandCount"`
+			SeriousADRs    []string            `bson:"seriousAdrs"`
+			Effects        map[string][]string `bson:"effects"`
+			FirstMentioned primitive.DateTime  `bson:"firstMentioned"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			log.Printf("Medication decode error: %v", err)
+			continue
+		}
+		entry, ok := normalizeDrug(doc.Name)
+		if !ok {
+			unknown += 1
+			continue
+		}
+
+		if doc.FirstMentioned == 0 {
+			doc.FirstMentioned = primitive.NewDateTimeFromTime(time.Now().UTC())
+		}
+		addToSet := bson.M{"names": doc.Name}
+		lists := map[string][]string{"adrs": doc.ADRs, "firstHandAdrs": doc.FirstHandADRs, "seriousAdrs": doc.SeriousADRs}
+		for relation, terms := range doc.Effects {
+			key := effectsKey(relation)
+			lists[key] = append(lists[key], terms...)
+		}
+		for field, terms := range lists {
+			if len(terms) > 0 {
+				addToSet[field] = bson.M{"$each": terms}
+			}
+		}
+		update := bson.M{
+			"$addToSet": addToSet,
+			"$inc":      bson.M{"mentionCount": doc.MentionCount, "firstHandCount": doc.FirstHandCount},
+			"$min":      bson.M{"firstMentioned": doc.FirstMentioned},
+			"$set":      bson.M{"name": entry.Name, "atc": entry.ATC},
+		}
+		_, err := medicationsColl.UpdateOne(context.TODO(), bson.M{"drug_id": entry.ID}, update,
+			options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Medication merge error for %s: %v", doc.Name, err)
+			continue
+		}
+		if _, err := medicationsColl.DeleteOne(context.TODO(), bson.M{"_id": doc.ID}); err != nil {
+			log.Printf("Medication delete error for %s: %v", doc.Name, err)
+			continue
+		}
+		merged += 1
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("Medications cursor error: %v", err)
+	}
+	log.Printf("Normalized medications: %d merged into canonical IDs, %d not in the dictionary", merged, unknown)
+}
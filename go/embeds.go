@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Embed do post. Serve tanto para o formato cru (record.embed, Jetstream)
+// quanto para a view do searchPosts (post.embed, com o texto do post citado)
+type postEmbed struct {
+	Type     string         `json:"$type"`
+	Alt      string         `json:"alt"`
+	Images   []embedImage   `json:"images"`
+	External *embedExternal `json:"external"`
+	Record   *embedRecord   `json:"record"`
+	Media    *postEmbed     `json:"media"`
+}
+
+type embedImage struct {
+	Alt string `json:"alt"`
+}
+
+type embedExternal struct {
+	URI         string `json:"uri" bson:"uri"`
+	Title       string `json:"title" bson:"title"`
+	Description string `json:"description" bson:"description"`
+}
+
+// record (cru: uri/cid) ou viewRecord (uri/author/value); no recordWithMedia vem aninhado
+type embedRecord struct {
+	URI    string `json:"uri"`
+	Author *struct {
+		Handle string `json:"handle"`
+	} `json:"author"`
+	Value *struct {
+		Text string `json:"text"`
+	} `json:"value"`
+	Record *embedRecord `json:"record"`
+}
+
+type facet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []struct {
+		Type string `json:"$type"`
+		Tag  string `json:"tag"`
+		URI  string `json:"uri"`
+		DID  string `json:"did"`
+	} `json:"features"`
+}
+
+type quotedPost struct {
+	URI    string `bson:"uri"`
+	Handle string `bson:"handle,omitempty"`
+	Text   string `bson:"text,omitempty"`
+}
+
+// Resumo do embed salvo no documento do post
+type embedSummary struct {
+	Type     string         `bson:"type"`
+	AltTexts []string       `bson:"alt_texts,omitempty"`
+	External *embedExternal `bson:"external,omitempty"`
+	Quote    *quotedPost    `bson:"quote,omitempty"`
+}
+
+// Prefere a view (tem o texto do post citado) e cai para o record cru
+func (p Post) embed() *postEmbed {
+	if p.Embed != nil {
+		return p.Embed
+	}
+	return p.Record.Embed
+}
+
+func (p Post) embedSummary() *embedSummary {
+	e := p.embed()
+	if e == nil {
+		return nil
+	}
+	summary := &embedSummary{Type: strings.TrimSuffix(e.Type, "#view")}
+
+	for _, part := range []*postEmbed{e, e.Media} {
+		if part == nil {
+			continue
+		}
+		for _, img := range part.Images {
+			if img.Alt != "" {
+				summary.AltTexts = append(summary.AltTexts, img.Alt)
+			}
+		}
+		if part.Alt != "" {
+			summary.AltTexts = append(summary.AltTexts, part.Alt)
+		}
+		if part.External != nil {
+			summary.External = part.External
+		}
+	}
+
+	if rec := e.Record; rec != nil {
+		if rec.Record != nil {
+			rec = rec.Record
+		}
+		if rec.URI != "" {
+			summary.Quote = &quotedPost{URI: rec.URI}
+			if rec.Author != nil {
+				summary.Quote.Handle = rec.Author.Handle
+			}
+			if rec.Value != nil {
+				summary.Quote.Text = rec.Value.Text
+			}
+		}
+	}
+	return summary
+}
+
+// Hashtags, links e mencoes das facets (mais as tags soltas do record)
+func (p Post) facetFeatures() (tags, links, mentions []string) {
+	tags = append(tags, p.Record.Tags...)
+	for _, f := range p.Record.Facets {
+		for _, feature := range f.Features {
+			switch feature.Type {
+			case "app.bsky.richtext.facet#tag":
+				tags = append(tags, feature.Tag)
+			case "app.bsky.richtext.facet#link":
+				links = append(links, feature.URI)
+			case "app.bsky.richtext.facet#mention":
+				mentions = append(mentions, feature.DID)
+			}
+		}
+	}
+	return tags, links, mentions
+}
+
+// Texto das imagens e do post citado para o prompt (-embed-text)
+func embedPromptSection(summary *embedSummary) string {
+	if summary == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, alt := range summary.AltTexts {
+		sb.WriteString(fmt.Sprintf("Image description attached to the post: %s\n", alt))
+	}
+	if summary.Quote != nil && summary.Quote.Text != "" {
+		sb.WriteString(fmt.Sprintf("Post quoted by the post: %s\n", summary.Quote.Text))
+	}
+	if summary.External != nil && (summary.External.Title != "" || summary.External.Description != "") {
+		sb.WriteString(fmt.Sprintf("Link card attached to the post: %s - %s\n", summary.External.Title, summary.External.Description))
+	}
+	return sb.String()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Trecho do post citado pelo LLM como evidencia. Start/End sao offsets em bytes
+// em post.Record.Text; Grounded = o trecho foi encontrado no texto
+type evidenceSpan struct {
+	Quote    string `json:"quote" bson:"quote"`
+	Start    int    `json:"start" bson:"start"`
+	End      int    `json:"end" bson:"end"`
+	Grounded bool   `json:"grounded" bson:"grounded"`
+}
+
+// Resposta JSON do Prompt 5
+type llmAnalysis struct {
+	Medications []llmMedication `json:"medications"`
+}
+
+type llmMedication struct {
+	Name        *string         `json:"name"`
+	Evidence    string          `json:"evidence"`
+	Experiencer string          `json:"experiencer"`
+	Therapy     *therapyDetails `json:"therapy"`
+	ADRs        []llmADR        `json:"adrs"`
+}
+
+type llmADR struct {
+	Term        string   `json:"term"`
+	Relation    string   `json:"relation"`
+	Assertion   string   `json:"assertion"`
+	Severity    string   `json:"severity"`
+	Seriousness []string `json:"seriousness"`
+	Evidence    string   `json:"evidence"`
+}
+
+// Aceita tambem ADRs como strings simples
+func (a *llmADR) UnmarshalJSON(data []byte) error {
+	var term string
+	if err := json.Unmarshal(data, &term); err == nil {
+		a.Term = term
+		return nil
+	}
+	type plain llmADR
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// Bloco de codigo markdown em volta do JSON (```json ... ```)
+var codeFenceRe = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+
+// Resposta no formato antigo: medicine,adr,adr|medicine,adr numa linha, sem
+// pontuacao de frase nem de JSON
+var legacyAnswerRe = regexp.MustCompile(`^[^{}\[\]":;.!?\n|]+(\|[^{}\[\]":;.!?\n|]+)*$`)
+
+// Nomes e ADRs do formato antigo sao curtos; mais palavras e texto corrido
+const legacyMaxWords = 6
+
+func isLegacyAnswer(answer string) bool {
+	if !legacyAnswerRe.MatchString(answer) {
+		return false
+	}
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == '|' || r == ',' }) {
+		if len(strings.Fields(field)) > legacyMaxWords {
+			return false
+		}
+	}
+	return true
+}
+
+// Le a resposta JSON do LLM: o objeto vai do primeiro "{" ao ultimo "}", entao
+// texto antes ou depois dele ("Here is the analysis: {...}") e ignorado. Sem
+// JSON, so o formato antigo (medicine,adr|...) do parseMedications e aceito; o
+// resto (JSON cortado pelo max_tokens, texto solto) e erro, nunca vira medicamento
+func parseAnalysis(answer string, query string) ([]Medication, error) {
+	raw := strings.TrimSpace(answer)
+	if m := codeFenceRe.FindStringSubmatch(raw); m != nil {
+		raw = m[1]
+	}
+	start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}")
+	if start < 0 {
+		if !isLegacyAnswer(raw) {
+			return nil, fmt.Errorf("answer is neither JSON nor a medicine,adr|... list")
+		}
+		// O formato antigo so lista ADRs
+		medications := parseMedications(raw, query)
+		for i := range medications {
+			for j := range medications[i].ADRs {
+				medications[i].ADRs[j].Relation = relationAdverse
+			}
+		}
+		return medications, nil
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid analysis JSON: no closing brace")
+	}
+
+	var parsed llmAnalysis
+	if err := json.Unmarshal([]byte(raw[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid analysis JSON: %w", err)
+	}
+
+	medications := make([]Medication, 0, len(parsed.Medications))
+	for _, m := range parsed.Medications {
+		med := Medication{Name: query, Experiencer: m.Experiencer, Therapy: normalizeTherapy(m.Therapy)}
+		if m.Name != nil && strings.TrimSpace(*m.Name) != "" && strings.TrimSpace(*m.Name) != "X" {
+			med.Name = strings.TrimSpace(*m.Name)
+		}
+		if m.Evidence != "" {
+			med.Evidence = &evidenceSpan{Quote: m.Evidence}
+		}
+		for _, adr := range m.ADRs {
+			term := strings.TrimSpace(adr.Term)
+			if term == "" || term == "X" {
+				continue
+			}
+			mention := adrMention{Verbatim: term, Relation: normalizeRelation(adr.Relation), Assertion: adr.Assertion, Severity: adr.Severity, Seriousness: adr.Seriousness}
+			if adr.Evidence != "" {
+				mention.Evidence = &evidenceSpan{Quote: adr.Evidence}
+			}
+			med.ADRs = append(med.ADRs, mention)
+		}
+		medications = append(medications, med)
+	}
+	return medications, nil
+}
+
+// Texto normalizado (sem acento, minusculo, pontuacao e espacos viram um espaco)
+// com o inicio e o fim, em bytes no texto original, da runa de cada byte normalizado
+func normalizeForMatch(text string) (string, []int, []int) {
+	var sb strings.Builder
+	var starts, ends []int
+	space := true
+	for i, r := range text {
+		end := i + utf8.RuneLen(r)
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if !space {
+				sb.WriteByte(' ')
+				starts, ends = append(starts, i), append(ends, end)
+				space = true
+			}
+			continue
+		}
+		folded := foldText(string(r))
+		sb.WriteString(folded)
+		for range len(folded) {
+			starts, ends = append(starts, i), append(ends, end)
+		}
+		space = false
+	}
+	return sb.String(), starts, ends
+}
+
+// Localiza a citacao no texto: igual depois da normalizacao ou, se nao houver,
+// a janela de palavras mais parecida (ate ~1 edicao a cada 8 letras)
+func groundQuote(text, quote string) *evidenceSpan {
+	span := &evidenceSpan{Quote: quote}
+	normText, starts, ends := normalizeForMatch(text)
+	normQuote, _, _ := normalizeForMatch(quote)
+	normQuote = strings.TrimSpace(normQuote)
+	if normQuote == "" {
+		return span
+	}
+
+	start := indexWords(normText, normQuote)
+	end := start + len(normQuote)
+	if start < 0 {
+		start, end = closestWindow(normText, normQuote)
+	}
+	if start < 0 {
+		return span
+	}
+	span.Start, span.End, span.Grounded = starts[start], ends[end-1], true
+	return span
+}
+
+// Como strings.Index, mas so aceita a citacao em palavras inteiras ("sono" nao
+// casa dentro de "sonolenta"). O texto normalizado separa palavras com um espaco
+func indexWords(normText, normQuote string) int {
+	for from := 0; from <= len(normText)-len(normQuote); {
+		i := strings.Index(normText[from:], normQuote)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		end := i + len(normQuote)
+		if (i == 0 || normText[i-1] == ' ') && (end == len(normText) || normText[end] == ' ') {
+			return i
+		}
+		from = i + 1
+	}
+	return -1
+}
+
+// Janela com o mesmo numero de palavras da citacao e a menor distancia de edicao
+func closestWindow(normText, normQuote string) (int, int) {
+	n := len(strings.Fields(normQuote))
+	limit := len(normQuote) / 8
+	if limit == 0 {
+		return -1, -1
+	}
+
+	// Inicio (em bytes) de cada palavra do texto normalizado
+	var starts []int
+	for i := 0; i < len(normText); i++ {
+		if normText[i] != ' ' && (i == 0 || normText[i-1] == ' ') {
+			starts = append(starts, i)
+		}
+	}
+
+	bestStart, bestEnd, bestDistance := -1, -1, limit+1
+	for w := 0; w+n <= len(starts); w++ {
+		end := len(normText)
+		if w+n < len(starts) {
+			end = starts[w+n] - 1
+		}
+		if d := levenshtein(normText[starts[w]:end], normQuote); d < bestDistance {
+			bestStart, bestEnd, bestDistance = starts[w], end, d
+		}
+	}
+	return bestStart, bestEnd
+}
+
+// Verifica as evidencias de medicamentos e ADRs contra o texto do post. A
+// citacao e obrigatoria: ADR sem citacao fica marcada como nao localizada.
+// Com -ungrounded drop, ADRs sem citacao no post sao descartadas
+// (medicamentos so sao marcados, o detector lexico ja os confere)
+func groundAnalysis(analysis []Medication, text string) []Medication {
+	for i := range analysis {
+		med := &analysis[i]
+		if med.Evidence != nil {
+			med.Evidence = groundQuote(text, med.Evidence.Quote)
+		}
+		kept := med.ADRs[:0]
+		for _, adr := range med.ADRs {
+			if adr.Verbatim != "X" {
+				quote := ""
+				if adr.Evidence != nil {
+					quote = adr.Evidence.Quote
+				}
+				adr.Evidence = groundQuote(text, quote)
+				if !adr.Evidence.Grounded && *ungroundedFlag == "drop" {
+					continue
+				}
+			}
+			kept = append(kept, adr)
+		}
+		med.ADRs = kept
+	}
+	return analysis
+}
@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+// Quem teve as reacoes relatadas para o medicamento
+const (
+	experiencerSelf       = "self"        // o autor do post (relato em primeira mao)
+	experiencerRelative   = "relative"    // parente ou conhecido ("minha mae")
+	experiencerThirdParty = "third_party" // terceiro nao especificado ("tem gente que")
+	experiencerCommentary = "commentary"  // comentario geral ou noticia
+)
+
+// Gatilhos pt/es/en (sem acento, minusculas) para quando o LLM nao responde
+var experiencerCues = map[string][]string{
+	experiencerRelative: {
+		"minha mae", "meu pai", "minha irma", "meu irmao", "minha filha", "meu filho", "minha esposa", "meu marido",
+		"minha namorada", "meu namorado", "minha avo", "meu avo", "minha tia", "meu tio", "minha amiga", "meu amigo",
+		"mi madre", "mi mama", "mi padre", "mi papa", "mi hermana", "mi hermano", "mi hija", "mi hijo", "mi esposa",
+		"mi esposo", "mi marido", "mi novia", "mi novio", "mi abuela", "mi abuelo", "mi amiga", "mi amigo",
+		"my mom", "my mother", "my dad", "my father", "my sister", "my brother", "my daughter", "my son", "my wife",
+		"my husband", "my girlfriend", "my boyfriend", "my grandma", "my grandpa", "my friend",
+	},
+	experiencerThirdParty: {
+		"tem gente", "muita gente", "as pessoas", "pessoas que", "conheco gente", "dizem que", "falam que",
+		"hay gente", "mucha gente", "la gente", "dicen que",
+		"some people", "many people", "people who", "people say", "i heard",
+	},
+	experiencerCommentary: {
+		"estudo", "pesquisa", "segundo a", "segundo o", "anvisa",
+		"estudio", "investigacion", "segun",
+		"study", "research", "according to", "fda",
+	},
+	experiencerSelf: {
+		"eu", "me", "meu", "minha", "comigo", "tomo", "tomei", "tomando",
+		"yo", "mi", "conmigo", "tome",
+		"i", "my", "i m", "i ve", "taking",
+	},
+}
+
+// Palavras antes da citacao de uma ADR olhadas junto com ela
+const experiencerADRWindow = 3
+
+func normalizeExperiencer(experiencer string) string {
+	key := strings.Join(strings.Fields(strings.ToLower(strings.NewReplacer("-", " ", "/", " ").Replace(experiencer))), "_")
+	switch key {
+	case experiencerSelf, "author", "first_hand", "first_person":
+		return experiencerSelf
+	case experiencerRelative, "family", "family_member", "acquaintance", "friend":
+		return experiencerRelative
+	case experiencerThirdParty, "other", "others", "third_person":
+		return experiencerThirdParty
+	case experiencerCommentary, "news", "general", "general_commentary":
+		return experiencerCommentary
+	}
+	return ""
+}
+
+// Gatilho de pessoa (autor, parente, terceiros) colado a citacao de alguma ADR:
+// "fluoxetina me deu sono" e relato do autor mesmo num post que cita um estudo
+func adrExperiencer(med Medication, text string) string {
+	for _, adr := range med.ADRs {
+		if adr.Evidence == nil || !adr.Evidence.Grounded {
+			continue
+		}
+		before := text[:adr.Evidence.Start]
+		if k := strings.LastIndexAny(before, ".!?\n"); k >= 0 {
+			before = before[k+1:]
+		}
+		normBefore, _, _ := normalizeForMatch(before)
+		words := strings.Fields(normBefore)
+		if len(words) > experiencerADRWindow {
+			words = words[len(words)-experiencerADRWindow:]
+		}
+		normQuote, _, _ := normalizeForMatch(text[adr.Evidence.Start:adr.Evidence.End])
+		context := " " + strings.Join(append(words, strings.Fields(normQuote)...), " ") + " "
+		if class, _ := longestCue(context, experiencerCues, []string{experiencerRelative, experiencerThirdParty, experiencerSelf}); class != "" {
+			return class
+		}
+	}
+	return ""
+}
+
+// Usa a resposta do LLM; se ele nao disser, procura gatilhos junto das
+// citacoes das ADRs e depois na frase que cita o medicamento (ou no post
+// todo), onde entram os de comentario. Sem gatilho fica vazio (desconhecido)
+func assessExperiencers(analysis []Medication, text string) []Medication {
+	for i := range analysis {
+		med := &analysis[i]
+		med.Experiencer = normalizeExperiencer(med.Experiencer)
+		if med.Experiencer != "" {
+			continue
+		}
+		if med.Experiencer = adrExperiencer(*med, text); med.Experiencer != "" {
+			continue
+		}
+		context := text
+		if med.Evidence != nil && med.Evidence.Grounded {
+			start := strings.LastIndexAny(text[:med.Evidence.Start], ".!?\n") + 1
+			end := len(text)
+			if k := strings.IndexAny(text[med.Evidence.End:], ".!?\n"); k >= 0 {
+				end = med.Evidence.End + k
+			}
+			context = text[start:end]
+		}
+		normContext, _, _ := normalizeForMatch(context)
+		words := " " + strings.TrimSpace(normContext) + " "
+		med.Experiencer, _ = longestCue(words, experiencerCues, []string{experiencerRelative, experiencerThirdParty, experiencerCommentary, experiencerSelf})
+	}
+	return analysis
+}
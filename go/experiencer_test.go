@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestAssessExperiencers(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		medQuote    string
+		adrQuote    string
+		llm         string
+		experiencer string
+	}{
+		{"primeira pessoa num post que cita estudo", "estudo mostra que fluoxetina me deu sono", "fluoxetina", "sono", "", experiencerSelf},
+		{"citacao da ADR em primeira pessoa", "estudo mostra que fluoxetina me deu sono", "fluoxetina", "me deu sono", "", experiencerSelf},
+		{"comentario sem pessoa perto da ADR", "estudo mostra que fluoxetina causa sonolência em adultos", "fluoxetina", "sonolência", "", experiencerCommentary},
+		{"parente na frase do medicamento", "minha mãe tomou fluoxetina e ficou com muito sono", "fluoxetina", "muito sono", "", experiencerRelative},
+		{"parente perto da ADR", "tomo fluoxetina. minha mãe ficou tonta com ela", "fluoxetina", "ficou tonta", "", experiencerRelative},
+		{"terceiros", "tem gente que toma sertralina e engorda", "sertralina", "engorda", "", experiencerThirdParty},
+		{"LLM manda quando responde", "estudo mostra que fluoxetina me deu sono", "fluoxetina", "sono", "commentary", experiencerCommentary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := []Medication{{
+				Name:        "Fluoxetine",
+				Experiencer: tt.llm,
+				Evidence:    &evidenceSpan{Quote: tt.medQuote},
+				ADRs:        []adrMention{{Verbatim: "ADR", Relation: relationAdverse, Evidence: &evidenceSpan{Quote: tt.adrQuote}}},
+			}}
+			analysis = assessExperiencers(groundAnalysis(analysis, tt.text), tt.text)
+			if got := analysis[0].Experiencer; got != tt.experiencer {
+				t.Errorf("experiencer = %q, want %q", got, tt.experiencer)
+			}
+		})
+	}
+}
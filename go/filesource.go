@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	rawSourceFile     = "file"
+	fileSourcePageLen = 100
+)
+
+// Le posts de arquivos locais (JSONL, CSV ou dumps do Reddit, opcionalmente .gz).
+// O cursor e "arquivo:registro", para retomar no meio de um arquivo grande
+type fileSource struct {
+	name  string
+	files []string
+	// Decodifica um registro; false descarta o registro. nil = fileRecord
+	decode func(data []byte) (Post, bool, error)
+}
+
+// Formato do importador generico; em CSV os nomes vem do cabecalho
+type fileRecord struct {
+	ID        string `json:"id"`
+	URI       string `json:"uri"`
+	Text      string `json:"text"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"created_at"`
+	Lang      string `json:"lang"`
+	Query     string `json:"query"`
+}
+
+func (f *fileSource) Name() string { return f.name }
+
+func (f *fileSource) Search(ctx context.Context, query string, cp *checkpoint, page func([]Post) error) error {
+	return searchByStream(f, ctx, query, cp, page)
+}
+
+func (f *fileSource) Stream(ctx context.Context, cp *checkpoint, page func([]Post) error) error {
+	resumeFile, resumeRecord := "", 0
+	if i := strings.LastIndex(cp.Cursor, ":"); i >= 0 {
+		resumeFile = cp.Cursor[:i]
+		resumeRecord, _ = strconv.Atoi(cp.Cursor[i+1:])
+	}
+
+	skipping := resumeFile != ""
+	// Cursor de um arquivo que nao esta nos argumentos desta execucao: le tudo
+	if skipping && !slices.Contains(f.files, resumeFile) {
+		log.Printf("Checkpoint file %s is not in the arguments, reading all files from the start", resumeFile)
+		skipping = false
+	}
+	for _, path := range f.files {
+		skip := 0
+		if skipping {
+			if path != resumeFile {
+				log.Printf("Skipping %s: already read", path)
+				continue
+			}
+			skipping, skip = false, resumeRecord
+		}
+
+		var posts []Post
+		n, err := readRecords(path, func(n int, data []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if n <= skip {
+				return nil
+			}
+			post, ok, err := f.decodeRecord(data)
+			if err != nil {
+				log.Printf("%s record %d: %v", path, n, err)
+				return nil
+			}
+			if ok {
+				if post.URI == "" {
+					post.URI = fileRecordURI(data)
+				}
+				posts = append(posts, post)
+			}
+			if len(posts) >= fileSourcePageLen {
+				cp.Cursor = fmt.Sprintf("%s:%d", path, n)
+				cp.Retrieved += len(posts)
+				err, posts = page(posts), nil
+				return err
+			}
+			return nil
+		})
+		if err == nil && len(posts) > 0 {
+			cp.Cursor = fmt.Sprintf("%s:%d", path, n)
+			cp.Retrieved += len(posts)
+			err = page(posts)
+		}
+		if err == errStopPaging {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("Read %s: %d records", path, n)
+	}
+	return nil
+}
+
+func (f *fileSource) decodeRecord(data []byte) (Post, bool, error) {
+	if f.decode != nil {
+		return f.decode(data)
+	}
+	post, err := decodeFileRecord(data)
+	return post, err == nil && post.Record.Text != "", err
+}
+
+// URI de registros sem id: hash do conteudo, para nao colidir entre arquivos
+// com o mesmo nome ou entre execucoes lendo o stdin (o mesmo registro em dois
+// datasets continua sendo o mesmo post)
+func fileRecordURI(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "file:sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// Chama fn com cada registro (numerado a partir de 1) em JSON; linhas de CSV
+// viram um objeto com as colunas do cabecalho. "-" le o stdin.
+// Devolve o numero de registros lidos
+func readRecords(path string, fn func(n int, data []byte) error) (int, error) {
+	file := os.Stdin
+	if path != "-" {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			return 0, err
+		}
+		defer file.Close()
+	}
+
+	var r io.Reader = file
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		r, name = zr, strings.TrimSuffix(name, ".gz")
+	}
+
+	n := 0
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return 0, fmt.Errorf("csv header: %w", err)
+		}
+		for {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return n, nil
+			}
+			if err != nil {
+				return n, err
+			}
+			record := map[string]string{}
+			for i, value := range row {
+				if i < len(header) {
+					record[strings.ToLower(strings.TrimSpace(header[i]))] = value
+				}
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				return n, err
+			}
+			n += 1
+			if err := fn(n, data); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Comentarios e selftexts do Reddit passam facilmente do limite padrao de 64KB
+	scanner.Buffer(make([]byte, 0, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		n += 1
+		if err := fn(n, append([]byte(nil), line...)); err != nil {
+			return n, err
+		}
+	}
+	return n, scanner.Err()
+}
+
+func decodeFileRecord(data []byte) (Post, error) {
+	var record fileRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return Post{}, err
+	}
+
+	var post Post
+	post.URI = record.URI
+	if post.URI == "" {
+		post.URI = record.ID
+	}
+	post.Author.DID = record.Author
+	post.Author.Handle = record.Author
+	post.Record.Text = record.Text
+	post.Record.CreatedAt = record.CreatedAt
+	if record.Lang != "" {
+		post.Record.Langs = []string{record.Lang}
+	}
+	post.Query = strings.TrimSpace(record.Query)
+	post.Raw, post.RawSource = data, rawSourceFile
+	return post, nil
+}
@@ -9,24 +9,28 @@ import (
   "bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"strconv"
   "strings"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-  "golang.org/x/exp/slices"
 )
 
 // .env
 func init() {
+	// Opcional: fora do container as variaveis podem vir do ambiente
 	if err := godotenv.Load("/app/.env"); err != nil {
-		log.Fatal("Error loading .env file")
+		log.Printf("No .env file loaded: %v", err)
 	}
 }
 
@@ -39,9 +43,45 @@ type Post struct {
 		DisplayName string `json:"displayName"`
 	} `json:"author"`
 	Record struct {
-		Text      string    `json:"text"`
-		CreatedAt string    `json:"createdAt"`
+		Text      string `json:"text"`
+		CreatedAt string `json:"createdAt"`
+		Reply     *struct {
+			Root   postRef `json:"root"`
+			Parent postRef `json:"parent"`
+		} `json:"reply,omitempty"`
+		Langs  []string   `json:"langs"`
+		Tags   []string   `json:"tags"`
+		Facets []facet    `json:"facets"`
+		Embed  *postEmbed `json:"embed"`
 	} `json:"record"`
+	Embed     *postEmbed `json:"embed"`
+	IndexedAt string     `json:"indexedAt"`
+
+	// Query definida pela propria fonte (importacao de arquivos); vazia = atribuida pelo texto
+	Query string `json:"-"`
+
+	// JSON original como veio da API, guardado em posts_raw
+	Raw       json.RawMessage `json:"-"`
+	RawSource string          `json:"-"`
+}
+
+func (p *Post) UnmarshalJSON(data []byte) error {
+	type plain Post
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	p.Raw = append(json.RawMessage(nil), data...)
+	p.RawSource = rawSourceView
+	return nil
+}
+
+// Horario usado pelo searchPosts para ordenar e filtrar (since/until)
+func (p Post) sortTime() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, p.IndexedAt); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339Nano, p.Record.CreatedAt)
+	return t
 }
 
 type SessionResponse struct {
@@ -54,6 +94,11 @@ var (
   mongoClient       *mongo.Client
 	postsColl         *mongo.Collection
 	medicationsColl   *mongo.Collection
+	backfillColl      *mongo.Collection
+	checkpointsColl   *mongo.Collection
+	rawPostsColl      *mongo.Collection
+	vocabularyColl    *mongo.Collection
+	mergesColl        *mongo.Collection
 )
 
 func initDB() {
@@ -65,6 +110,11 @@ func initDB() {
 	mongoClient = client
 	postsColl = mongoClient.Database("bluesky_data").Collection("posts")
   medicationsColl = mongoClient.Database("bluesky_data").Collection("medications")
+	backfillColl = mongoClient.Database("bluesky_data").Collection("backfill_windows")
+	checkpointsColl = mongoClient.Database("bluesky_data").Collection("checkpoints")
+	rawPostsColl = mongoClient.Database("bluesky_data").Collection("posts_raw")
+	vocabularyColl = mongoClient.Database("bluesky_data").Collection("adr_vocabulary")
+	mergesColl = mongoClient.Database("bluesky_data").Collection("adr_merges")
 
 	// Index unico
 	indexModel := mongo.IndexModel{
@@ -75,10 +125,28 @@ func initDB() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	_, err = rawPostsColl.Indexes().CreateOne(context.TODO(), indexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = vocabularyColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "term", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Triagem de posts graves
+	_, err = postsColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "serious", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 // Deepseek API
-func generateTextDeepSeek(prompt string) (string, error) {
+func generateTextDeepSeek(ctx context.Context, prompt string) (string, error) {
 	client := &http.Client{}
 	reqBody := map[string]interface{}{
 		"model": "deepseek-chat",
@@ -86,14 +154,14 @@ func generateTextDeepSeek(prompt string) (string, error) {
 			{"role": "user", "content": prompt},
 		},
 		"temperature": 0.7,
-		"max_tokens":  500,
+		"max_tokens":  2048,
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -144,7 +212,7 @@ func generateTextDeepSeek(prompt string) (string, error) {
 
 
 // OpenRouter API
-func generateTextOpenRouter(prompt string) (string, error) {
+func generateTextOpenRouter(ctx context.Context, prompt string) (string, error) {
 	client := &http.Client{}
 	reqBody := map[string]interface{}{
 		"model": "google/gemini-2.0-flash-001",
@@ -152,14 +220,14 @@ func generateTextOpenRouter(prompt string) (string, error) {
 			{"role": "user", "content": prompt},
 		},
 		"temperature": 0.7,
-		"max_tokens":  500,
+		"max_tokens":  2048,
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -210,7 +278,7 @@ func generateTextOpenRouter(prompt string) (string, error) {
 
 
 // OpenAI API
-func generateTextOpenAI(prompt string) (string, error) {
+func generateTextOpenAI(ctx context.Context, prompt string) (string, error) {
 	client := &http.Client{}
 	reqBody := map[string]interface{}{
 		"model": "gpt-4o-mini",
@@ -218,14 +286,14 @@ func generateTextOpenAI(prompt string) (string, error) {
 			{"role": "user", "content": prompt},
 		},
 		"temperature": 0.7,
-		"max_tokens":  500,
+		"max_tokens":  2048,
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -274,7 +342,7 @@ func generateTextOpenAI(prompt string) (string, error) {
 	return result.Choices[0].Message.Content, nil
 }
 
-func generateTextLocalLLM(prompt string) (string, error) {
+func generateTextLocalLLM(ctx context.Context, prompt string) (string, error) {
   // DEEP_THINKING_INSTRUCTION := "Enable deep thinking subroutine."
   client := &http.Client{}
 	reqBody := map[string]interface{}{
@@ -288,12 +356,12 @@ func generateTextLocalLLM(prompt string) (string, error) {
 			{"role": "user", "content": prompt},
 		},
 		"temperature": 0.7,
-		"max_tokens":  1024,
+		"max_tokens":  2048,
 	}
 
 	jsonBody, _ := json.Marshal(reqBody)
 
-	req, _ := http.NewRequest("POST", "http://127.0.0.1:8000/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://127.0.0.1:8000/v1/chat/completions", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
   // Cliente customizado com 20 segundos de timeout
@@ -330,7 +398,8 @@ func generateTextLocalLLM(prompt string) (string, error) {
 	if len(result.Choices) > 0 {
     message := result.Choices[0].Message.Content
 
-    fmt.Printf("%s\n", message)
+    // Vai para o log: o stdout pode ser a saida JSONL do analyze
+    log.Printf("%s\n", message)
 
     // Remover <think> tags e o conteudo dentro
     re := regexp.MustCompile(`(?s)<think>.*?</think>`)
@@ -344,9 +413,12 @@ func generateTextLocalLLM(prompt string) (string, error) {
 	return "", errors.New("No choices returned from API")
 }
 
-func generateTextUmbrella(prompt string) (string, error) {
+func generateTextUmbrella(ctx context.Context, prompt string) (string, error) {
     conn := connectToServer()
     defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
 
     // First confirm we can receive the welcome message
     welcomeLength := make([]byte, 4)
@@ -360,12 +432,12 @@ func generateTextUmbrella(prompt string) (string, error) {
         return "", fmt.Errorf("error reading welcome data: %v", err)
     }
 
-    fmt.Printf("Welcome message: %s\n", string(welcomeData))
+    log.Printf("Welcome message: %s\n", string(welcomeData))
 
     // Simple request with only required fields
     req := APIRequest{
         Context:      prompt,
-        MaxNewTokens: 512,
+        MaxNewTokens: 2048,
         Temperature:  0.7,
     }
 
@@ -410,7 +482,7 @@ func sendRequest(conn net.Conn, req APIRequest) (string, error) {
     }
 
     // Debug - print what we're sending
-    fmt.Printf("Sending data: %s\n", string(data))
+    log.Printf("Sending data: %s\n", string(data))
 
     // Send length prefix
     length := make([]byte, 4)
@@ -436,7 +508,7 @@ func sendRequest(conn net.Conn, req APIRequest) (string, error) {
         return "", fmt.Errorf("error reading response data: %v", err)
     }
 
-    fmt.Printf("Received response: %s\n", string(responseData))
+    log.Printf("Received response: %s\n", string(responseData))
     return string(responseData), nil
 }
 
@@ -466,32 +538,57 @@ func connectToServer() net.Conn {
 	return nil
 }
 
-// BlueSky autenticacao
-func authenticate() string {
+// BlueSky autenticacao. Erros voltam para o chamador, que desiste so da query
+func authenticate(client *rateLimitedClient) (string, error) {
 	reqBody := map[string]string{
 		"identifier": os.Getenv("BLUESKY_USERNAME"),
 		"password":   os.Getenv("BLUESKY_APP_PASSWORD"),
 	}
 	jsonBody, _ := json.Marshal(reqBody)
 
-	resp, err := http.Post(
-		"https://bsky.social/xrpc/com.atproto.server.createSession",
-		"application/json",
-		bytes.NewBuffer(jsonBody),
-	)
+	req, err := http.NewRequest("POST", "https://bsky.social/xrpc/com.atproto.server.createSession", bytes.NewBuffer(jsonBody))
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("createSession failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("createSession returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
 	var session SessionResponse
-	json.NewDecoder(resp.Body).Decode(&session)
-	return session.AccessJWT
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return "", fmt.Errorf("failed to decode createSession response: %w", err)
+	}
+	if session.AccessJWT == "" {
+		return "", fmt.Errorf("createSession returned no access token")
+	}
+	return session.AccessJWT, nil
 }
 
 type Medication struct {
-    Name string
-    ADRs []string
+    Name string   `json:"name"`
+    ADRs []adrMention `json:"adrs"`
+
+    // Preenchidos quando o nome esta no dicionario (-drugs); Verbatim e o nome devolvido pelo LLM
+    DrugID   string `json:"drug_id,omitempty" bson:"drug_id,omitempty"`
+    ATC      string `json:"atc,omitempty" bson:"atc,omitempty"`
+    Verbatim string `json:"verbatim,omitempty" bson:"verbatim,omitempty"`
+    // Nome que o detector lexico nao achou no post (provavel alucinacao)
+    NotInPost bool `json:"not_in_post,omitempty" bson:"not_in_post,omitempty"`
+    // Trecho do post que cita o medicamento (so na resposta JSON)
+    Evidence *evidenceSpan `json:"evidence,omitempty" bson:"evidence,omitempty"`
+    // Quem teve as reacoes: self, relative, third_party ou commentary ("" = desconhecido)
+    Experiencer string `json:"experiencer,omitempty" bson:"experiencer,omitempty"`
+    // Dose, frequencia, via, inicio/fim, duracao e tempo ate a reacao, se o post disser
+    Therapy *therapyDetails `json:"therapy,omitempty" bson:"therapy,omitempty"`
 }
 
 func parseMedications(input string, query string) []Medication {
@@ -513,9 +610,9 @@ func parseMedications(input string, query string) []Medication {
           medicine = query
         }
 
-        med := Medication{
-            Name: medicine,
-            ADRs: parts[1:],
+        med := Medication{Name: medicine}
+        for _, adr := range parts[1:] {
+            med.ADRs = append(med.ADRs, adrMention{Verbatim: adr})
         }
 
         medications = append(medications, med)
@@ -524,254 +621,688 @@ func parseMedications(input string, query string) []Medication {
     return medications
 }
 
-func main() {
-  benchmarkTime := time.Now();
-
-  var queryList = [...]string{"Venvanse", "Aripiprazol", "Fluoxetina", "Escitalopram", "Sertralina", "Ritalina", "Atentah", "Concerta", "Bupropiona", "Risperidona", "Paroxetina", "Venlafaxina", "Vortioxetina",  "Agomelatina", "Desvenlafaxina", "Duloxetina", "Vortioxetina", "Nefazodona", " Trazodona", "Clonazepam", "Alprazolam", "Lorazepam", "Bromazepam", "Diazepam", "Amitriptilina", "Clomipramina", "Desipramina", "Doxepina", "Imipramina", "Maprotilina", "Nortriptilina", "Protriptilina", "Trimipramina", "Puran", "Salonpas", "Cliclo", "Microvlar", "Buscopan", "Rivotril", "Dorflex", "Glifage"}
-  var adrList = []string{"Nausea", "Apathy", "Anxiety", "Sleepiness", "Arrhythmia"}
-
-  for _, query := range queryList {
-    initDB()
-    accessToken := authenticate()
-    client := &http.Client{}
-    maxResults := 500
-    totalRetrieved := 0
-    cursor := ""
-    for {
-      req, _ := http.NewRequest("GET", "https://bsky.social/xrpc/app.bsky.feed.searchPosts", nil)
-      q := req.URL.Query()
-      q.Add("q", query)
-      q.Add("limit", "100")
-
-      if cursor != "" {
-        q.Add("cursor", cursor)
-      }
+// Parametros de uma busca no app.bsky.feed.searchPosts
+type searchParams struct {
+	Query  string
+	Cursor string
+	Sort   string
+	Since  time.Time
+	Until  time.Time
+}
 
-      req.URL.RawQuery = q.Encode()
-      req.Header.Add("Authorization", "Bearer "+accessToken)
+type searchResult struct {
+	Posts  []Post `json:"posts"`
+	Cursor string `json:"cursor"`
+}
 
-      resp, err := client.Do(req)
-      if err != nil {
-        log.Fatal(err)
-      }
+// Resumo das paginas percorridas por collectPages
+type pageStats struct {
+	Retrieved    int
+	Oldest       time.Time
+	LastPageFull bool
+	Exhausted    bool
+}
 
-      var result struct {
-        Posts  []Post `json:"posts"`
-        Cursor string `json:"cursor"`
-      }
+const searchPageLimit = 100
 
-      json.NewDecoder(resp.Body).Decode(&result)
-      resp.Body.Close()
-
-      log.Printf("API: %d posts, cursor=%v, Total=%d",
-    len(result.Posts), result.Cursor != "", totalRetrieved)
-
-      for _, post := range result.Posts {
-
-        // Prompt 1
-
-        // prompt := fmt.Sprintf(`Answer with the side effects in english for yes and X for no.
-        // 	DO NOT EXPLAIN OR COMMENT
-        // 	The answer MUST above all be a single character or a list of just the name of the side effect without any aditional commentary/information/detail or an X for when this is not applied.
-        // 	Does this text talk about %s and its side effects.
-        // 	If the text only talks about %s and the symptoms that afflicts them but aren't specifically side effects from %s, answer with no (X).
-        // 	Answer with the main side effects in english only if the side effects are from %s and they are bad.
-        //   If there are multiple side effects, separate them with a single comma without any whitespace
-        //   Text: %s`, query, query, query, query, post.Record.Text)
-
-        // Prompt 2
-
-        // prompt := fmt.Sprintf(`
-        // You are a pharmacovigilance specialist and you are analyzing the side effects regarding %s in social media posts.
-        // Answer with the side effects in english if there are any and X for no.
-        // YOU MUST BE ABLE TO UNDERSTAND AND INTERPRET INFORMAL LANGUAGE IN ANY LANGUAGE, YOU MUST NOT CONFUSE SIDE EFFECTS WITH THE SYMPTHOMS THE MEDICINE SOLVES OR GIVES WHEN ONE STOPS TAKING IT
-        // YOU MUST NOT ASSUME THE WHAT THE SIDE EFFECTS ARE, YOU SHOULD EXTRACT IT FROM THE TEXT
-        // DO NOT EXPLAIN OR COMMENT
-        // The answer MUST above all be a single character or a list of just the name of the side effect without any aditional commentary/information/detail or an X for when this is not applied.
-        // Does this post talk about %s and its side effects, physical or emotional?
-        // If the text only talks about %s and the symptoms that afflicts them but aren't specifically side effects from %s, answer with no (X).
-        // Answer with the main side effects in english only if the side effects are from %s and they are bad or undesirable.
-        // If there are multiple side effects, separate them with a single comma without any whitespace
-        // Post: %s`, query, query, query, query, post.Record.Text)
-
-
-        // Prompt 3
-
-        // prompt := fmt.Sprintf(`
-
-        //   Only answer in english in a single line with the output following these templates
-        //   medicine is always first
-        //   (adr is adverse drug reaction)
-        //   replace each one with the actual medicine and the actual respective adrs
-        //   if an adr is non existent, put an upper case X instead
-        //   Each list has a head (the first element), the head will always be the medicine name and the rest will be the adrs
-        //   USE the following separator ":" to separate the lists
-        //   <medicine1>,<adr1>:<medicine2>,<adr1>
-
-        //   DO NOT DEVIATE FROM THE OUTPUT TEMPLATE
-        //   Example 1 of output:
-        //   <medicine1>,<adr1>
-        //   Example 2 of output:
-        //   <medicine1>,<adr1>,<adr2>,<adr3>
-        //   Example 3 of output:
-        //   <medicine1>,<adr1>,<adr2>:<medicine1>,<adr1>,<adr2>,<adr3>
-        //   Example 4 of outupt:
-        //   <medicine1>,<adr1>:<medicine2>,<adr1>:<medicine3>,<adr1>,<adr2>,<adr3>
-
-        //   You are a pharmacovigilance specialist and you are analyzing the side effects regarding medicines in social media posts.
-        //   YOU MUST BE ABLE TO UNDERSTAND AND INTERPRET INFORMAL LANGUAGE IN ANY LANGUAGE, YOU MUST NOT CONFUSE SIDE EFFECTS WITH THE SYMPTHOMS THE MEDICINE SOLVES
-        //   YOU MUST NOT ASSUME  WHAT THE SIDE EFFECTS ARE, YOU SHOULD EXTRACT IT FROM THE TEXT AND RESUME IT
-        // 	DO NOT EXPLAIN OR COMMENT
-        // 	Does this post talk about a medicine and its side effects, physical or emotional?
-        //   Put an X in the first adr field for the respective medicine if it's talking about sympthons that are not related to the medicine
-        // 	Translate to english the main side effects each resumed in a one or two words and the name of the medicine
-        //   Post: %s`, post.Record.Text)
-
-
-        // Prompt 4
-        prompt := fmt.Sprintf(`
-
-          Only answer in english in a single line with the output following these templates
-          medicine is always first
-          (adr is adverse drug reaction)
-          replace each one with the actual medicine and the actual respective adrs
-          Each list has a head (the first element), the head will always be the medicine name and the rest will be the adrs
-          USE the following separator "|" to separate the lists like in:
-          medicine1,adr1|medicine2,adr1
-
-          Example 1 of output if there is a single medicine with a single adr: medicine1,adr1
-          Example 2 of output: medicine1,adr1,adr2,adr3
-          Example 3 of output with multiple medicines and multiple adrs: medicine1,adr1,adr2|medicine1,adr1,adr2,adr3,adr4
-          Example 4 of outupt: medicine1,adr1|medicine2,adr1|medicine3,adr1,adr2,adr3
-          Example 5 of output if there is just a medicine: medicine1
-          Example 6 of output if theree is just adrs and no medicine: X,adr1,adr2,adr3
-
-          So if the Post was: 'Fluoxetina me da nausea e apatia, Venvanse me deixa ansiosa'
-          The output would be for example (DO NOT COPY THIS IS AN EXAMPLE):
-          Fluoxetine,Nausea,Apathy|Venvanse,Anxiety
-
-          BUT ONLY DO THAT IF THE USER IS TALKING ABOUT A MEDICINE AND THEIR SIDE EFFECTS, PUT JUST THE NAME OF THE MEDICINE IF THAT IS NOT THE CASE
-          CAPTURE THE NAMES OF THE MEDICINES AND THEIR ADVERSE REACTIONS RESUMED, DO NOT CAPTURE ANYTHING ELSE
-          AVOID AT ALL COSTS NOTES, OBSERVATIONS OR ANY COMMENTARY
-
-          Does this post talk about a medicine and its side effects, physical or emotional?
-
-          USE THIS LIST AS REFERENCE FOR THE ADRS: %S. ONLY DEVIATE FROM THE LIST IF THE ADR IS NOT ABSOLUTELY NOT PRESENT ON THE LIST FOR EXAPLE SOMNOLENCE IS THE SAME AS SLEEPINESS SO SLEEPINESS SHOULD BE USED
-
-          Post: %s`, strings.Join(adrList, ","), post.Record.Text)
-
-      print(fmt.Sprintf("\n***\nADRs Lista: %s\n***\n", strings.Join(adrList, ",")))
-
-
-      answer, errGeneration := generateTextOpenRouter(prompt)
-        if errGeneration != nil {
-          log.Printf("Error: %v", errGeneration)
-        }
+var (
+	maxResults = 500
+)
 
-        analysis := parseMedications(answer,query)
-
-        var medicationUpdates []mongo.WriteModel
-        for _, med := range analysis {
-          if med.Name == "" {
-            continue
-          }
-          // Filtrar fora 'X' (Que significa sem ADRs)
-          filteredADRs := make([]string, 0)
-          for _, adr := range med.ADRs {
-            if adr != "X" {
-              filteredADRs = append(filteredADRs, adr)
-              if !slices.Contains(adrList, adr) {
-                adrList = append(adrList, adr)
-              }
-            }
-          }
-
-          if len(filteredADRs) == 0 {
-            continue
-          }
-
-          // Filtro Case-insensitive
-          filter := bson.M{
-            "name": bson.M{
-              "$regex":   "^" + regexp.QuoteMeta(med.Name) + "$",
-              "$options": "i", // Case-insensitive
-            },
-          }
-
-          update := bson.M{
-            "$addToSet": bson.M{
-              "adrs": bson.M{"$each": filteredADRs},
-            },
-            "$inc": bson.M{"mentionCount": 1},
-            "$setOnInsert": bson.M{
-              "name":          med.Name,
-              "firstMentioned": primitive.NewDateTimeFromTime(time.Now().UTC()),
-            },
-          }
-
-          model := mongo.NewUpdateOneModel().
-            SetFilter(filter).
-            SetUpdate(update).
-            SetUpsert(true)
-
-          medicationUpdates = append(medicationUpdates, model)
-        }
+// Flags
+var (
+	queryFlag           = flag.String("query", "", "comma-separated subset of the watchlist to run (any name of a drug selects it)")
+	adrTermsFlag        = flag.String("adr-terms", "adr_terms.json", "ADR terminology (preferred terms, system organ classes, synonyms) used to code ADRs")
+	dmeFlag             = flag.String("dme", "dme.json", "designated medical events: ADR terms always flagged as serious (medically_important)")
+	drugsFlag           = flag.String("drugs", "drugs.json", "drug dictionary (canonical ID, ATC code, synonyms) used to normalize medication names")
+	watchlistFlag       = flag.String("watchlist", "watchlist.json", "drug watchlist JSON file, or mongo to read the watchlist collection")
+	sinceFlag           = flag.String("since", "", "backfill: oldest date to collect (YYYY-MM-DD or RFC3339)")
+	untilFlag           = flag.String("until", "", "backfill: newest date to collect (YYYY-MM-DD or RFC3339), defaults to now")
+	windowFlag          = flag.Duration("window", 30*24*time.Hour, "backfill: initial size of each since/until slice")
+	minWindowFlag       = flag.Duration("min-window", time.Hour, "backfill: smallest slice size when shrinking")
+	resumeFlag          = flag.Bool("resume", false, "continue each query from its saved checkpoint")
+	threadFlag          = flag.Bool("thread-context", true, "fetch parent/root posts of replies and include them in the prompt")
+	reanalyzeFlag       = flag.Bool("reanalyze", false, "send posts that are already stored to the LLM again")
+	providerFlag        = flag.String("provider", "openrouter", "LLM provider: deepseek, openrouter, openai, local or umbrella")
+	concurrencyFlag     = flag.String("concurrency", "", "per-provider limit of simultaneous LLM calls, e.g. local=2,openrouter=16")
+	workersFlag         = flag.Int("workers", 4, "number of LLM workers")
+	batchSizeFlag       = flag.Int("batch-size", 20, "posts written to MongoDB per batch")
+	shutdownGraceFlag   = flag.Duration("shutdown-grace", 30*time.Second, "on SIGINT/SIGTERM, how long in-flight LLM calls may take to finish")
+	embedTextFlag       = flag.Bool("embed-text", false, "include image alt text, quoted post text and link cards in the prompt")
+	incrementalFlag     = flag.Bool("incremental", false, "search: only fetch posts newer than the last stored one for each query")
+	sourceFlag          = flag.String("source", "bluesky", "post source: bluesky, mastodon, reddit (Pushshift dumps) or file (JSONL/CSV); files are given as arguments")
+	outputFlag          = flag.String("output", "stdout", "analyze: stdout (JSONL) or mongo")
+	embeddingsURLFlag   = flag.String("embeddings-url", "http://127.0.0.1:8000/v1/embeddings", "cluster-adrs: OpenAI-compatible embeddings endpoint")
+	embeddingsModelFlag = flag.String("embeddings-model", "", "cluster-adrs: model sent to the embeddings endpoint (empty = server default)")
+	mergeThresholdFlag  = flag.Float64("merge-threshold", 0.85, "cluster-adrs: cosine similarity needed to propose merging two ADR terms")
+	ungroundedFlag      = flag.String("ungrounded", "mark", "ADRs whose evidence quote is not in the post: mark (grounded: false) or drop")
+	countAssertionsFlag = flag.String("count-assertions", assertionAffirmed, "comma-separated ADR assertion statuses counted in medications and vocabulary (affirmed, negated, hypothetical, uncertain)")
+	jetstreamFlag       = flag.String("jetstream", "wss://jetstream2.us-east.bsky.network/subscribe", "stream: Jetstream websocket endpoint")
+)
 
-        if len(medicationUpdates) > 0 {
-          _, err := medicationsColl.BulkWrite(context.TODO(), medicationUpdates)
-          if err != nil {
-            log.Printf("Medication update error: %v", err)
-          }
-        }
+func searchPosts(ctx context.Context, client *rateLimitedClient, accessToken string, params searchParams) (searchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://bsky.social/xrpc/app.bsky.feed.searchPosts", nil)
+	if err != nil {
+		return searchResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	q := req.URL.Query()
+	q.Add("q", params.Query)
+	q.Add("limit", strconv.Itoa(searchPageLimit))
 
-        createdAt, _ := time.Parse(time.RFC3339Nano, post.Record.CreatedAt)
-        var documents []interface{}
-        documents = append(documents, bson.M{
-          "post_uri": post.URI,
-          "author": bson.M{
-            "did":          post.Author.DID,
-            "handle":       post.Author.Handle,
-            "display_name": post.Author.DisplayName,
-          },
-          "content":      post.Record.Text,
-          "created_at":   primitive.NewDateTimeFromTime(createdAt),
-          "indexed_at":   primitive.NewDateTimeFromTime(time.Now().UTC()),
-          "query": query,
-          "rawOutput": answer,
-          "analysis": analysis,
-        })
-        _, err := postsColl.InsertMany(context.TODO(), documents, options.InsertMany().SetOrdered(true))
-        if err != nil {
-          log.Printf("Insert error: %v", err)
-        }
+	if params.Cursor != "" {
+		q.Add("cursor", params.Cursor)
+	}
+	if params.Sort != "" {
+		q.Add("sort", params.Sort)
+	}
+	if !params.Since.IsZero() {
+		q.Add("since", params.Since.UTC().Format(time.RFC3339))
+	}
+	if !params.Until.IsZero() {
+		q.Add("until", params.Until.UTC().Format(time.RFC3339))
+	}
 
-        totalRetrieved += 1
-        fmt.Printf("Posts verificados: %d\n---\n\n", totalRetrieved)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-        print(fmt.Sprintf(`Usuario: %s
+	resp, err := client.Do(req)
+	if err != nil {
+		return searchResult{}, err
+	}
+	defer resp.Body.Close()
 
-  `, post.Author.DisplayName))
-        print(fmt.Sprintf(`Texto: %s
+	if resp.StatusCode == http.StatusUnauthorized {
+		return searchResult{}, fmt.Errorf("searchPosts: %w", errUnauthorized)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return searchResult{}, fmt.Errorf("searchPosts returned %s: %s", resp.Status, body)
+	}
 
-  `, post.Record.Text))
-        print(fmt.Sprintf(`Output: %s
-  `, answer))
-        print(fmt.Sprintf(`Analise: %s
+	var result searchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return searchResult{}, fmt.Errorf("failed to decode searchPosts response: %w", err)
+	}
+	return result, nil
+}
+
+// Percorre as paginas de uma busca ate o cursor acabar ou atingir o limite.
+// Cursor e totais vao para o checkpoint antes de cada pagina ser entregue a page,
+// que pode devolver errStopPaging para encerrar antes do fim
+func searchPages(ctx context.Context, session *blueskySession, params searchParams, limit int, cp *checkpoint, page func([]Post) error) (pageStats, error) {
+	stats := pageStats{Retrieved: cp.Retrieved, Oldest: cp.Oldest}
+	params.Cursor = cp.Cursor
+	for {
+		result, err := session.search(ctx, params)
+		if err != nil {
+			return stats, err
+		}
+
+		log.Printf("API: %d posts, cursor=%v, Total=%d",
+			len(result.Posts), result.Cursor != "", stats.Retrieved)
+
+		for _, post := range result.Posts {
+			if t := post.sortTime(); !t.IsZero() && (stats.Oldest.IsZero() || t.Before(stats.Oldest)) {
+				stats.Oldest = t
+			}
+			stats.Retrieved += 1
+		}
+
+		stats.LastPageFull = len(result.Posts) >= searchPageLimit
+		params.Cursor = result.Cursor
 
-  ---
+		cp.Cursor = result.Cursor
+		cp.Retrieved = stats.Retrieved
+		cp.Oldest = stats.Oldest
 
-  `, analysis))
+		err = page(result.Posts)
+		if err == errStopPaging || params.Cursor == "" {
+			stats.Exhausted = true
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		if stats.Retrieved >= limit {
+			return stats, nil
+		}
+	}
+}
+
+// Busca do BlueSky direto para o pipeline (usada pelo backfill)
+func collectPages(p *pipeline, session *blueskySession, params searchParams, limit int, cp *checkpoint) (pageStats, error) {
+	return searchPages(p.ctx, session, params, limit, cp, func(posts []Post) error {
+		return submitPage(p, cp, posts, fixedQuery(canonicalName(params.Query)), false)
+	})
+}
+
+// Etapa dos workers: contexto da thread, prompt e chamada ao LLM
+func analyzePost(ctx context.Context, job postJob) postResult {
+  post, query := job.post, job.query
+  result := postResult{postJob: job}
+
+  var thread *threadContext
+  if *threadFlag {
+    var err error
+    thread, err = fetchThreadContext(ctx, post)
+    if err != nil {
+      log.Printf("Thread context error for %s: %v", post.URI, err)
+    }
+  } else if reply := post.Record.Reply; reply != nil {
+    thread = &threadContext{RootURI: reply.Root.URI, ParentURI: reply.Parent.URI}
+  }
 
+  // Candidatos do detector lexico, marcados no prompt
+  mentions := detectDrugMentions(post.Record.Text)
+
+  embed := post.embedSummary()
+  embedSection := ""
+  if *embedTextFlag {
+    embedSection = embedPromptSection(embed)
+  }
+
+  // Prompt 1
+
+  // prompt := fmt.Sprintf(`Answer with the side effects in english for yes and X for no.
+  // 	DO NOT EXPLAIN OR COMMENT
+  // 	The answer MUST above all be a single character or a list of just the name of the side effect without any aditional commentary/information/detail or an X for when this is not applied.
+  // 	Does this text talk about %s and its side effects.
+  // 	If the text only talks about %s and the symptoms that afflicts them but aren't specifically side effects from %s, answer with no (X).
+  // 	Answer with the main side effects in english only if the side effects are from %s and they are bad.
+  //   If there are multiple side effects, separate them with a single comma without any whitespace
+  //   Text: %s`, query, query, query, query, post.Record.Text)
+
+  // Prompt 2
+
+  // prompt := fmt.Sprintf(`
+  // You are a pharmacovigilance specialist and you are analyzing the side effects regarding %s in social media posts.
+  // Answer with the side effects in english if there are any and X for no.
+  // YOU MUST BE ABLE TO UNDERSTAND AND INTERPRET INFORMAL LANGUAGE IN ANY LANGUAGE, YOU MUST NOT CONFUSE SIDE EFFECTS WITH THE SYMPTHOMS THE MEDICINE SOLVES OR GIVES WHEN ONE STOPS TAKING IT
+  // YOU MUST NOT ASSUME THE WHAT THE SIDE EFFECTS ARE, YOU SHOULD EXTRACT IT FROM THE TEXT
+  // DO NOT EXPLAIN OR COMMENT
+  // The answer MUST above all be a single character or a list of just the name of the side effect without any aditional commentary/information/detail or an X for when this is not applied.
+  // Does this post talk about %s and its side effects, physical or emotional?
+  // If the text only talks about %s and the symptoms that afflicts them but aren't specifically side effects from %s, answer with no (X).
+  // Answer with the main side effects in english only if the side effects are from %s and they are bad or undesirable.
+  // If there are multiple side effects, separate them with a single comma without any whitespace
+  // Post: %s`, query, query, query, query, post.Record.Text)
+
+
+  // Prompt 3
+
+  // prompt := fmt.Sprintf(`
+
+  //   Only answer in english in a single line with the output following these templates
+  //   medicine is always first
+  //   (adr is adverse drug reaction)
+  //   replace each one with the actual medicine and the actual respective adrs
+  //   if an adr is non existent, put an upper case X instead
+  //   Each list has a head (the first element), the head will always be the medicine name and the rest will be the adrs
+  //   USE the following separator ":" to separate the lists
+  //   <medicine1>,<adr1>:<medicine2>,<adr1>
+
+  //   DO NOT DEVIATE FROM THE OUTPUT TEMPLATE
+  //   Example 1 of output:
+  //   <medicine1>,<adr1>
+  //   Example 2 of output:
+  //   <medicine1>,<adr1>,<adr2>,<adr3>
+  //   Example 3 of output:
+  //   <medicine1>,<adr1>,<adr2>:<medicine1>,<adr1>,<adr2>,<adr3>
+  //   Example 4 of outupt:
+  //   <medicine1>,<adr1>:<medicine2>,<adr1>:<medicine3>,<adr1>,<adr2>,<adr3>
+
+  //   You are a pharmacovigilance specialist and you are analyzing the side effects regarding medicines in social media posts.
+  //   YOU MUST BE ABLE TO UNDERSTAND AND INTERPRET INFORMAL LANGUAGE IN ANY LANGUAGE, YOU MUST NOT CONFUSE SIDE EFFECTS WITH THE SYMPTHOMS THE MEDICINE SOLVES
+  //   YOU MUST NOT ASSUME  WHAT THE SIDE EFFECTS ARE, YOU SHOULD EXTRACT IT FROM THE TEXT AND RESUME IT
+  // 	DO NOT EXPLAIN OR COMMENT
+  // 	Does this post talk about a medicine and its side effects, physical or emotional?
+  //   Put an X in the first adr field for the respective medicine if it's talking about sympthons that are not related to the medicine
+  // 	Translate to english the main side effects each resumed in a one or two words and the name of the medicine
+  //   Post: %s`, post.Record.Text)
+
+
+  // Prompt 4
+  // prompt := fmt.Sprintf(`
+
+  //   Only answer in english in a single line with the output following these templates
+  //   medicine is always first
+  //   (adr is adverse drug reaction)
+  //   replace each one with the actual medicine and the actual respective adrs
+  //   Each list has a head (the first element), the head will always be the medicine name and the rest will be the adrs
+  //   USE the following separator "|" to separate the lists like in:
+  //   medicine1,adr1|medicine2,adr1
+
+  //   Example 1 of output if there is a single medicine with a single adr: medicine1,adr1
+  //   Example 2 of output: medicine1,adr1,adr2,adr3
+  //   Example 3 of output with multiple medicines and multiple adrs: medicine1,adr1,adr2|medicine1,adr1,adr2,adr3,adr4
+  //   Example 4 of outupt: medicine1,adr1|medicine2,adr1|medicine3,adr1,adr2,adr3
+  //   Example 5 of output if there is just a medicine: medicine1
+  //   Example 6 of output if theree is just adrs and no medicine: X,adr1,adr2,adr3
+
+  //   So if the Post was: 'Fluoxetina me da nausea e apatia, Venvanse me deixa ansiosa'
+  //   The output would be for example (DO NOT COPY THIS IS AN EXAMPLE):
+  //   Fluoxetine,Nausea,Apathy|Venvanse,Anxiety
+
+  //   BUT ONLY DO THAT IF THE USER IS TALKING ABOUT A MEDICINE AND THEIR SIDE EFFECTS, PUT JUST THE NAME OF THE MEDICINE IF THAT IS NOT THE CASE
+  //   CAPTURE THE NAMES OF THE MEDICINES AND THEIR ADVERSE REACTIONS RESUMED, DO NOT CAPTURE ANYTHING ELSE
+  //   AVOID AT ALL COSTS NOTES, OBSERVATIONS OR ANY COMMENTARY
+
+  //   Does this post talk about a medicine and its side effects, physical or emotional?
+
+  //   USE THIS LIST AS REFERENCE FOR THE ADRS: %s. ONLY DEVIATE FROM THE LIST IF THE ADR IS NOT ABSOLUTELY NOT PRESENT ON THE LIST FOR EXAPLE SOMNOLENCE IS THE SAME AS SLEEPINESS SO SLEEPINESS SHOULD BE USED
+
+  //   %s
+  //   Post: %s
+  //   %s
+  //   %s`, adrReference(query), threadPromptSection(thread), post.Record.Text, drugMentionsPromptSection(mentions), embedSection)
+
+
+  // Prompt 5 (JSON com trechos do post como evidencia; respostas no formato do Prompt 4 ainda sao aceitas)
+  prompt := fmt.Sprintf(`
+
+    You are a pharmacovigilance specialist analyzing a social media post.
+    Answer ONLY with a JSON object in english, without notes, observations, commentary or markdown, following this schema:
+    {"medications": [{"name": "<medicine>", "evidence": "<exact quote from the post naming the medicine>", "experiencer": "<experiencer>", "therapy": {"dose": "<dose or strength>", "frequency": "<how often>", "route": "<route>", "start": "<when therapy started>", "stop": "<when therapy stopped>", "duration": "<for how long>", "onset": "<time from start to the effects>"}, "adrs": [{"term": "<effect resumed in one or two words>", "relation": "<relation>", "assertion": "<assertion>", "severity": "<severity>", "seriousness": [<criteria>], "evidence": "<exact quote from the post describing it>"}]}]}
+
+    List in adrs every effect the post relates to a medicine, with relation being exactly one of:
+    adverse_reaction: an undesirable effect caused by the medicine
+    indication: a symptom or condition the medicine is taken to treat
+    withdrawal: an effect of stopping, skipping or reducing the medicine
+    beneficial: a desired effect the medicine had
+    lack_of_efficacy: the medicine did not work for what it was taken for
+
+    And assertion being exactly one of:
+    affirmed: the post says the effect happened
+    negated: the post says the effect did not happen ('nao me deu sono')
+    hypothetical: the effect is feared, conditional or only possible ('tenho medo de engordar')
+    uncertain: the author is not sure the effect happened or was caused by the medicine ('acho que')
+
+    And experiencer, who took the medicine and had the effects, being exactly one of:
+    self: the author of the post
+    relative: a relative or acquaintance of the author ('minha mae')
+    third_party: unspecified other people ('tem gente que fala bem de fluoxetina')
+    commentary: general commentary or news, nobody in particular
+
+    severity is mild, moderate or severe, as intense as the post describes the effect.
+    seriousness lists the ICH E2A criteria the post reports for the effect, usually none ([]):
+    death, life_threatening, hospitalization (or prolonged hospitalization), disability, congenital_anomaly,
+    medically_important (needed medical intervention to prevent one of the others, e.g. seizures, arrhythmia, suicidal ideation)
+
+    therapy holds what the post says about how the medicine was used, copying the post's expressions ('20mg', 'duas vezes ao dia',
+    'comecei em 2019', 'ha 3 meses', 'depois de duas semanas'); route is oral, sublingual, intravenous, intramuscular, subcutaneous,
+    topical, transdermal, inhaled, nasal, rectal or ophthalmic. Use null for anything the post does not say, and omit therapy if it says none.
+    Prices and pill counts are not doses ('150 reais' is not a dose).
+
+    Each evidence MUST be copied exactly from the post, in its original language, as short as possible.
+    If the post talks about adverse reactions without naming the medicine, use null as the name.
+    If the post names a medicine without side effects, use an empty adrs list.
+    If there is no medicine and no adverse reaction, answer {"medications": []}
+
+    So if the Post was: 'Fluoxetina me da nausea e apatia, Venvanse me deixa ansiosa'
+    The output would be for example (DO NOT COPY THIS IS AN EXAMPLE):
+    {"medications": [{"name": "Fluoxetine", "evidence": "Fluoxetina", "experiencer": "self", "adrs": [{"term": "Nausea", "relation": "adverse_reaction", "assertion": "affirmed", "evidence": "nausea"}, {"term": "Apathy", "relation": "adverse_reaction", "assertion": "affirmed", "evidence": "apatia"}]}, {"name": "Venvanse", "evidence": "Venvanse", "experiencer": "self", "adrs": [{"term": "Anxiety", "relation": "adverse_reaction", "assertion": "affirmed", "evidence": "me deixa ansiosa"}]}]}
+    And for 'Tomo sertralina pra depressao, ajudou mas quando parei tive tontura':
+    {"medications": [{"name": "Sertraline", "evidence": "sertralina", "experiencer": "self", "therapy": {"dose": null, "frequency": null, "route": null, "start": null, "stop": "quando parei", "duration": null, "onset": null}, "adrs": [{"term": "Depression", "relation": "indication", "assertion": "affirmed", "evidence": "pra depressao"}, {"term": "Dizziness", "relation": "withdrawal", "assertion": "affirmed", "evidence": "quando parei tive tontura"}]}]}
+
+    YOU MUST NOT CONFUSE SIDE EFFECTS WITH THE SYMPTOMS THE MEDICINE TREATS, TAG THEM AS indication
+    CAPTURE ONLY THE MEDICINES AND THE ADVERSE REACTIONS THE POST ACTUALLY MENTIONS, NEVER ASSUME THEM
+
+    USE THIS LIST AS REFERENCE FOR THE ADR TERMS: %s. ONLY DEVIATE FROM THE LIST IF THE ADR IS ABSOLUTELY NOT PRESENT ON IT
+
+    %s
+    Post: %s
+    %s
+    %s`, adrReference(query), threadPromptSection(thread), post.Record.Text, drugMentionsPromptSection(mentions), embedSection)
+
+  answer, errGeneration := generateText(ctx, prompt)
+  if errGeneration != nil {
+    // Sem resposta do LLM nao grava (como um cancelamento): gravado com a analise
+    // vazia o post seria pulado como ja analisado e nunca mais tentado
+    log.Printf("Analysis of %s dropped: %v", post.URI, errGeneration)
+    result.canceled = true
+    return result
+  }
+
+  result.thread = thread
+  result.answer = answer
+  result.mentions = mentions
+  parsed, errParse := parseAnalysis(answer, query)
+  if errParse != nil {
+    log.Printf("Analysis of %s discarded: %v", post.URI, errParse)
+    result.parseError = errParse.Error()
+  }
+  result.analysis = codeMedicationADRs(normalizeMedications(parsed))
+  result.analysis = groundAnalysis(result.analysis, post.Record.Text)
+  result.analysis = assessAssertions(result.analysis, post.Record.Text, post.Record.Langs)
+  result.analysis = assessExperiencers(result.analysis, post.Record.Text)
+  result.analysis = gradeSeriousness(result.analysis)
+  result.analysis = flagUnmentionedDrugs(result.analysis, mentions, post.Record.Text, embedSection)
+  // Cancelado no meio da chamada: nao grava, o post volta na proxima execucao
+  result.canceled = ctx.Err() != nil
+  return result
+}
+
+
+// Upserts dos medicamentos de uma analise. stored indica que o post ja estava
+// salvo (--reanalyze), entao o mentionCount nao e incrementado de novo
+func medicationModels(analysis []Medication, stored bool) []mongo.WriteModel {
+  var medicationUpdates []mongo.WriteModel
+  for _, med := range analysis {
+    if med.Name == "" {
+      continue
+    }
+    // Filtrar fora 'X' (Que significa sem ADRs)
+    filteredADRs := make([]string, 0)
+    // Indicacoes, efeitos de retirada etc. ficam separados por tipo de relacao
+    otherEffects := make(bson.M)
+    // Termos codificados; sem codificacao vai o texto original
+    for _, adr := range med.ADRs {
+      if adr.Verbatim == "X" {
+        continue
       }
+      if adr.counted() {
+        filteredADRs = append(filteredADRs, adr.coded())
+      } else if !adr.isADR() {
+        key := effectsKey(adr.Relation)
+        effects, _ := otherEffects[key].(bson.M)
+        if effects == nil {
+          effects = bson.M{"$each": []string{}}
+          otherEffects[key] = effects
+        }
+        effects["$each"] = append(effects["$each"].([]string), adr.coded())
+      }
+    }
+
+    if len(filteredADRs) == 0 && len(otherEffects) == 0 {
+      continue
+    }
 
-      cursor = result.Cursor
+    // Filtro Case-insensitive
+    filter := bson.M{
+      "name": bson.M{
+        "$regex":   "^" + regexp.QuoteMeta(med.Name) + "$",
+        "$options": "i", // Case-insensitive
+      },
+    }
 
-      if cursor == "" || totalRetrieved >= maxResults {
-        timeElapsed := time.Since(benchmarkTime)
-        print(fmt.Sprintf("\n--- Tempo total: %s ---\n", timeElapsed))
-        break
+    addToSet := bson.M{"adrs": bson.M{"$each": filteredADRs}}
+    for key, effects := range otherEffects {
+      addToSet[key] = effects
+    }
+    // Relatos em primeira mao ficam tambem separados para estatisticas so com eles
+    inc := bson.M{"mentionCount": 1}
+    // ADRs graves (ICH E2A ou DME), para triagem
+    seriousADRs := make([]string, 0)
+    for _, adr := range med.ADRs {
+      if adr.Serious && adr.counted() {
+        seriousADRs = append(seriousADRs, adr.coded())
       }
-      time.Sleep(1 * time.Second)
     }
+    if len(seriousADRs) > 0 {
+      addToSet["seriousAdrs"] = bson.M{"$each": seriousADRs}
+    }
+    if med.Experiencer == experiencerSelf && len(filteredADRs) > 0 {
+      addToSet["firstHandAdrs"] = bson.M{"$each": filteredADRs}
+      inc["firstHandCount"] = 1
+    }
+    update := bson.M{
+      "$addToSet": addToSet,
+      "$inc": inc,
+      "$setOnInsert": bson.M{
+        "name":          med.Name,
+        "firstMentioned": primitive.NewDateTimeFromTime(time.Now().UTC()),
+      },
+    }
+
+    // Medicamento do dicionario: um documento por ID, com os nomes ja vistos
+    if med.DrugID != "" {
+      filter = bson.M{"drug_id": med.DrugID}
+      update["$addToSet"].(bson.M)["names"] = med.Verbatim
+      update["$setOnInsert"].(bson.M)["drug_id"] = med.DrugID
+      update["$set"] = bson.M{"atc": med.ATC}
+    }
+    // mentionCount conta so relatos de ADR
+    if stored || len(filteredADRs) == 0 {
+      delete(update, "$inc")
+    }
+
+    model := mongo.NewUpdateOneModel().
+      SetFilter(filter).
+      SetUpdate(update).
+      SetUpsert(true)
+
+    medicationUpdates = append(medicationUpdates, model)
   }
+  return medicationUpdates
+}
+
+// Campos do documento derivados apenas do post (refeitos pelo comando reprocess)
+func postFields(post Post, thread *threadContext) bson.M {
+	createdAt, _ := time.Parse(time.RFC3339Nano, post.Record.CreatedAt)
+	document := bson.M{
+		"post_uri": post.URI,
+		"author": bson.M{
+			"did":          post.Author.DID,
+			"handle":       post.Author.Handle,
+			"display_name": post.Author.DisplayName,
+		},
+		"content":    post.Record.Text,
+		"created_at": primitive.NewDateTimeFromTime(createdAt),
+	}
+	if thread != nil {
+		document["thread"] = thread
+	}
+	if embed := post.embedSummary(); embed != nil {
+		document["embed"] = embed
+	}
+	tags, links, mentions := post.facetFeatures()
+	if len(post.Record.Langs) > 0 {
+		document["langs"] = post.Record.Langs
+	}
+	if len(tags) > 0 {
+		document["tags"] = tags
+	}
+	if len(links) > 0 {
+		document["links"] = links
+	}
+	if len(mentions) > 0 {
+		document["mentions"] = mentions
+	}
+	return document
+}
+
+// Queries selecionadas com -query, ou a lista inteira
+func selectedDrugs() []watchlistDrug {
+	if *queryFlag == "" {
+		return watchlist
+	}
+	var drugs []watchlistDrug
+	for _, q := range strings.Split(*queryFlag, ",") {
+		if q = strings.TrimSpace(q); q == "" {
+			continue
+		}
+		drug, ok := findDrug(q)
+		if !ok {
+			// Busca avulsa, so pelo termo informado
+			log.Printf("%s is not in the watchlist, searching it as is", q)
+			drug = watchlistDrug{Name: q}
+		}
+		drugs = append(drugs, drug)
+	}
+	return drugs
+}
+
+func main() {
+	benchmarkTime := time.Now()
+
+	// Primeiro argumento (opcional) e o comando, o resto sao flags
+	command, args := "search", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if err := configureProviders(); err != nil {
+		log.Fatal(err)
+	}
+	if *ungroundedFlag != "mark" && *ungroundedFlag != "drop" {
+		log.Fatalf("invalid -ungrounded %q: use mark or drop", *ungroundedFlag)
+	}
+
+	// SIGINT/SIGTERM param a busca; chamadas ao LLM em andamento tem -shutdown-grace
+	// para terminar e o que ja foi analisado e gravado. Um segundo sinal encerra na hora
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Printf("Shutting down: waiting up to %s for in-flight analyses", *shutdownGraceFlag)
+		stop()
+	}()
+
+	// O reprocess nao busca nem analisa posts, entao dispensa watchlist, dicionario e terminologia
+	if command != "reprocess" {
+		if err := loadWatchlist(*watchlistFlag); err != nil {
+			log.Fatalf("Error loading watchlist: %v", err)
+		}
+		if err := loadDrugDictionary(*drugsFlag); err != nil {
+			log.Fatalf("Error loading drug dictionary: %v", err)
+		}
+		if err := loadTerminology(*adrTermsFlag); err != nil {
+			log.Fatalf("Error loading ADR terminology: %v", err)
+		}
+		if err := loadDesignatedEvents(*dmeFlag); err != nil {
+			log.Fatalf("Error loading designated medical events: %v", err)
+		}
+	}
+
+	switch command {
+	case "search":
+		initDB()
+		src, err := newSource(*sourceFlag, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		p := newPipeline(ctx, *workersFlag, *batchSizeFlag, nil)
+		mode := "search"
+		if *incrementalFlag {
+			mode = "incremental"
+		}
+
+		// Cada nome do medicamento e uma busca (com checkpoint proprio); os posts
+		// sao atribuidos ao nome canonico
+	drugs:
+		for _, drug := range selectedDrugs() {
+			for _, query := range searchTerms(src, drug) {
+				cp := startCheckpoint(query, checkpointMode(src, mode))
+				if cp.Done || (cp.Retrieved > 0 && (cp.Cursor == "" || (pageLimited(src) && cp.Retrieved >= maxResults))) {
+					log.Printf("Skipping %s: already completed", query)
+					continue
+				}
+
+				err := src.Search(ctx, query, cp, func(posts []Post) error {
+					return submitPage(p, cp, posts, fixedQuery(drug.Name), *incrementalFlag)
+				})
+				if err != nil {
+					// Segue para a proxima query; o checkpoint permite retomar com --resume
+					log.Printf("Query %s aborted: %v", query, err)
+					if ctx.Err() != nil {
+						break drugs
+					}
+					continue
+				}
+				p.after(func() {
+					cp.Done = true
+					saveCheckpoint(cp)
+				})
+			}
+			p.after(func() {
+				timeElapsed := time.Since(benchmarkTime)
+				print(fmt.Sprintf("\n--- Tempo total: %s ---\n", timeElapsed))
+			})
+		}
+		p.close()
+		log.Print(p.summary(benchmarkTime))
+
+	case "backfill":
+		since, err := parseDateFlag(*sinceFlag)
+		if err != nil || since.IsZero() {
+			log.Fatalf("backfill requires a valid -since date: %v", err)
+		}
+		until, err := parseDateFlag(*untilFlag)
+		if err != nil {
+			log.Fatalf("invalid -until date: %v", err)
+		}
+		if until.IsZero() {
+			until = time.Now().UTC()
+		}
+
+		initDB()
+		// Uma sessao para o backfill todo, renovada pelo TTL ou num 401
+		session := newBlueskySession(newRateLimitedClient())
+		p := newPipeline(ctx, *workersFlag, *batchSizeFlag, nil)
+		for _, drug := range selectedDrugs() {
+			for _, query := range drug.queries() {
+				if ctx.Err() != nil {
+					break
+				}
+				runBackfill(p, session, query, since, until)
+			}
+
+			p.after(func() {
+				timeElapsed := time.Since(benchmarkTime)
+				print(fmt.Sprintf("\n--- Tempo total: %s ---\n", timeElapsed))
+			})
+		}
+		p.close()
+		log.Print(p.summary(benchmarkTime))
+
+	case "stream":
+		initDB()
+		src, err := newSource(*sourceFlag, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		p := newPipeline(ctx, *workersFlag, *batchSizeFlag, nil)
+		cp := startCheckpoint(streamCheckpointName(src), "stream")
+
+		// Posts do stream sao atribuidos a uma query pelo texto
+		err = src.Stream(ctx, cp, func(posts []Post) error {
+			return submitPage(p, cp, posts, matchedQuery, false)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Stream %s stopped: %v", src.Name(), err)
+		}
+		p.close()
+		log.Print(p.summary(benchmarkTime))
+
+	case "reprocess":
+		initDB()
+		reprocessRawPosts()
+
+	case "vocabulary":
+		initDB()
+		runVocabulary(flag.Args())
+
+	case "cluster-adrs":
+		initDB()
+		clusterVocabulary(ctx)
+
+	case "merges":
+		initDB()
+		runMerges(flag.Args())
+
+	case "normalize-medications":
+		initDB()
+		normalizeStoredMedications()
+
+	case "normalize-queries":
+		initDB()
+		normalizeStoredQueries()
+
+	case "analyze":
+		runAnalyze(ctx, flag.Args(), benchmarkTime)
+
+	default:
+		log.Fatalf("unknown command %q", command)
+	}
 }
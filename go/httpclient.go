@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	clientMaxRetries = 5
+	clientMaxBackoff = 2 * time.Minute
+
+	// Intervalo usado enquanto o servidor nao mandou headers ratelimit-*
+	clientDefaultInterval = 1 * time.Second
+)
+
+// Cliente HTTP das fontes (BlueSky, Mastodon) que respeita os headers
+// ratelimit-* e repete 429/5xx com backoff em vez de derrubar o processo
+type rateLimitedClient struct {
+	http *http.Client
+
+	// last e o horario reservado para a requisicao mais recente
+	mu        sync.Mutex
+	remaining int
+	reset     time.Time
+	last      time.Time
+}
+
+func newRateLimitedClient() *rateLimitedClient {
+	return &rateLimitedClient{
+		http:      &http.Client{Timeout: 30 * time.Second},
+		remaining: -1,
+	}
+}
+
+func (c *rateLimitedClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		if err := c.pace(ctx); err != nil {
+			return nil, err
+		}
+
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.http.Do(req)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if err == nil {
+			c.updateLimits(resp.Header)
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return resp, nil
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+
+			if wait := c.retryAfter(resp); wait > backoff {
+				backoff = wait
+			}
+		}
+
+		if attempt >= clientMaxRetries {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("Request to %s failed (attempt %d/%d): %v, retrying in %s", req.URL.Host, attempt, clientMaxRetries, err, backoff)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, err
+		}
+		if backoff *= 2; backoff > clientMaxBackoff {
+			backoff = clientMaxBackoff
+		}
+	}
+}
+
+// Espalha as requisicoes restantes ate o reset da janela. O horario da proxima
+// requisicao e reservado sob o mutex: os workers que buscam threads dividem o
+// cliente e, sem isso, calculariam a mesma espera e disparariam juntos
+func (c *rateLimitedClient) pace(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	untilReset := c.reset.Sub(now)
+	var next time.Time
+	switch {
+	case c.remaining < 0 || untilReset <= 0:
+		next = c.last.Add(clientDefaultInterval)
+	case c.remaining == 0:
+		next = c.reset
+		if !next.After(c.last) {
+			next = c.last.Add(clientDefaultInterval)
+		}
+		log.Printf("Rate limit exhausted, waiting %s for reset", time.Until(next).Round(time.Second))
+	default:
+		next = c.last.Add(untilReset / time.Duration(c.remaining))
+		// Vale ate o proximo header; quem chega depois ja ve a cota menor
+		c.remaining--
+	}
+	if next.Before(now) {
+		next = now
+	}
+	c.last = next
+	c.mu.Unlock()
+
+	return sleepContext(ctx, time.Until(next))
+}
+
+// time.Sleep que acorda se o contexto for cancelado
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *rateLimitedClient) updateLimits(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("ratelimit-remaining"))
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remaining = remaining
+	if reset, err := strconv.ParseInt(h.Get("ratelimit-reset"), 10, 64); err == nil {
+		c.reset = time.Unix(reset, 0)
+	}
+}
+
+// Tempo de espera indicado pelo servidor num 429
+func (c *rateLimitedClient) retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("ratelimit-reset"), 10, 64); err == nil {
+		return time.Until(time.Unix(reset, 0))
+	}
+	return 0
+}
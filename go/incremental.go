@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// created_at do post mais novo ja salvo para a query
+func newestStoredPost(query string) (time.Time, error) {
+	var doc struct {
+		CreatedAt primitive.DateTime `bson:"created_at"`
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetProjection(bson.M{"created_at": 1})
+	err := postsColl.FindOne(context.TODO(), bson.M{"query": query}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return doc.CreatedAt.Time().UTC(), nil
+}
+
+// URIs da pagina que ja estao na colecao de posts
+func knownPostURIs(posts []Post) (map[string]bool, error) {
+	known := make(map[string]bool)
+	if len(posts) == 0 {
+		return known, nil
+	}
+
+	uris := make([]string, 0, len(posts))
+	for _, post := range posts {
+		uris = append(uris, post.URI)
+	}
+
+	cur, err := postsColl.Find(context.TODO(),
+		bson.M{"post_uri": bson.M{"$in": uris}},
+		options.Find().SetProjection(bson.M{"post_uri": 1}),
+	)
+	if err != nil {
+		return known, err
+	}
+	var docs []struct {
+		URI string `bson:"post_uri"`
+	}
+	if err := cur.All(context.TODO(), &docs); err != nil {
+		return known, err
+	}
+	for _, doc := range docs {
+		known[doc.URI] = true
+	}
+	return known, nil
+}
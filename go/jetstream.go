@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/websocket"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Evento do Jetstream (somente os campos usados)
+type jetstreamEvent struct {
+	DID    string `json:"did"`
+	TimeUS int64  `json:"time_us"`
+	Kind   string `json:"kind"`
+	Commit *struct {
+		Operation  string          `json:"operation"`
+		Collection string          `json:"collection"`
+		RKey       string          `json:"rkey"`
+		Record     json.RawMessage `json:"record"`
+	} `json:"commit"`
+
+	raw json.RawMessage
+}
+
+type queryMatcher struct {
+	query string
+	re    *regexp.Regexp
+}
+
+var accentFolder = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// Minusculas e sem acentos, para comparar nomes de medicamentos
+func foldText(s string) string {
+	folded, _, err := transform.String(accentFolder, strings.ToLower(s))
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return folded
+}
+
+var (
+	matchersOnce sync.Once
+	matchers     []queryMatcher
+)
+
+// Matchers dos termos da watchlist, montados no primeiro uso
+func queryMatchers() []queryMatcher {
+	matchersOnce.Do(func() { matchers = buildQueryMatchers() })
+	return matchers
+}
+
+func buildQueryMatchers() []queryMatcher {
+	var matchers []queryMatcher
+	for _, drug := range watchlist {
+		for _, term := range drug.terms() {
+			matchers = append(matchers, queryMatcher{
+				query: drug.Name,
+				re:    regexp.MustCompile(`\b` + regexp.QuoteMeta(foldText(term)) + `\b`),
+			})
+		}
+	}
+	return matchers
+}
+
+// Medicamento da watchlist (nome canonico) mencionado no texto, se houver
+func matchQuery(matchers []queryMatcher, text string) (string, bool) {
+	folded := foldText(text)
+	for _, m := range matchers {
+		if m.re.MatchString(folded) {
+			return m.query, true
+		}
+	}
+	return "", false
+}
+
+// Converte um commit de app.bsky.feed.post no mesmo Post do searchPosts
+func (e jetstreamEvent) post() (Post, error) {
+	var post Post
+	if err := json.Unmarshal(e.Commit.Record, &post.Record); err != nil {
+		return post, err
+	}
+	post.URI = fmt.Sprintf("at://%s/%s/%s", e.DID, e.Commit.Collection, e.Commit.RKey)
+	post.Author.DID = e.DID
+	post.IndexedAt = time.UnixMicro(e.TimeUS).UTC().Format(time.RFC3339Nano)
+	post.Raw, post.RawSource = e.raw, rawSourceJetstream
+	return post, nil
+}
+
+// Consome o Jetstream ate o contexto ser cancelado, reconectando a partir do
+// ultimo cursor (time_us)
+func runJetstream(ctx context.Context, endpoint string, cp *checkpoint, page func([]Post) error) error {
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := streamJetstream(ctx, endpoint, cp, page)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errStopPaging) {
+			return nil
+		}
+		log.Printf("Jetstream disconnected: %v", err)
+
+		if time.Since(connectedAt) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Reconnecting in %s", backoff)
+		if sleepContext(ctx, backoff) != nil {
+			return nil
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// Agrupa os posts criados em paginas de ate 100 eventos ou 1s
+func streamJetstream(ctx context.Context, endpoint string, cp *checkpoint, page func([]Post) error) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid jetstream url: %w", err)
+	}
+	q := u.Query()
+	q.Set("wantedCollections", "app.bsky.feed.post")
+	if cp.Cursor != "" {
+		// Volta alguns segundos para nao perder eventos; duplicados caem no indice unico
+		if us, err := strconv.ParseInt(cp.Cursor, 10, 64); err == nil {
+			q.Set("cursor", strconv.FormatInt(us-5*time.Second.Microseconds(), 10))
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	conn, err := websocket.Dial(u.String(), "", "http://localhost/")
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	defer conn.Close()
+	log.Printf("Connected to %s", u.String())
+
+	// Fecha a conexao para destravar o Receive quando o contexto e cancelado
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	var posts []Post
+	events, cursor := 0, ""
+	lastFlush := time.Now()
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Minute))
+
+		var message []byte
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			return err
+		}
+
+		var event jetstreamEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			log.Printf("Jetstream decode error: %v", err)
+			continue
+		}
+		event.raw = message
+		cursor = strconv.FormatInt(event.TimeUS, 10)
+		events += 1
+
+		if event.Kind == "commit" && event.Commit != nil &&
+			event.Commit.Operation == "create" && event.Commit.Collection == "app.bsky.feed.post" {
+			post, err := event.post()
+			if err != nil {
+				log.Printf("Jetstream record decode error: %v", err)
+				continue
+			}
+			posts = append(posts, post)
+		}
+
+		if events >= 100 || time.Since(lastFlush) > time.Second {
+			cp.Cursor = cursor
+			if err := page(posts); err != nil {
+				return err
+			}
+			posts, events, lastFlush = nil, 0, time.Now()
+		}
+	}
+}
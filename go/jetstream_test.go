@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Jetstream local: a primeira conexao manda dois posts e cai; a segunda manda
+// mais um. O consumidor tem que reconectar a partir do cursor do ultimo lote
+func TestRunJetstreamReconnectsFromCursor(t *testing.T) {
+	const baseUS = int64(1700000000000000)
+	event := func(us int64, rkey, text string) string {
+		return fmt.Sprintf(`{"did":"did:plc:test","time_us":%d,"kind":"commit","commit":{"operation":"create","collection":"app.bsky.feed.post","rkey":%q,"record":{"text":%q,"createdAt":"2024-01-01T00:00:00Z"}}}`, us, rkey, text)
+	}
+
+	var mu sync.Mutex
+	var cursors []string
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		mu.Lock()
+		cursors = append(cursors, conn.Request().URL.Query().Get("cursor"))
+		connection := len(cursors)
+		mu.Unlock()
+
+		// O consumidor entrega o lote quando chega um evento mais de 1s depois do anterior
+		switch connection {
+		case 1:
+			websocket.Message.Send(conn, event(baseUS, "a", "fluoxetina me deu sono"))
+			time.Sleep(1100 * time.Millisecond)
+			websocket.Message.Send(conn, event(baseUS+1, "b", "sertralina me deu enjoo"))
+		default:
+			time.Sleep(1100 * time.Millisecond)
+			websocket.Message.Send(conn, event(baseUS+2, "c", "venvanse me deixou ansiosa"))
+			// Espera o consumidor encerrar
+			var message []byte
+			websocket.Message.Receive(conn, &message)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cp := &checkpoint{}
+	var texts []string
+	err := runJetstream(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), cp, func(posts []Post) error {
+		for _, post := range posts {
+			texts = append(texts, post.Record.Text)
+		}
+		if len(texts) == 3 {
+			return errStopPaging
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runJetstream: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("timed out with posts %q", texts)
+	}
+
+	if want := []string{"fluoxetina me deu sono", "sertralina me deu enjoo", "venvanse me deixou ansiosa"}; strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("posts = %q, want %q", texts, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(cursors) != 2 {
+		t.Fatalf("connections = %d, want 2", len(cursors))
+	}
+	if cursors[0] != "" {
+		t.Errorf("first connection cursor = %q, want none", cursors[0])
+	}
+	// Reconecta alguns segundos antes do ultimo evento entregue
+	if want := strconv.FormatInt(baseUS+1-5*time.Second.Microseconds(), 10); cursors[1] != want {
+		t.Errorf("reconnect cursor = %q, want %q", cursors[1], want)
+	}
+	if want := strconv.FormatInt(baseUS+2, 10); cp.Cursor != want {
+		t.Errorf("checkpoint cursor = %q, want %q", cp.Cursor, want)
+	}
+}
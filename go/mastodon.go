@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	rawSourceMastodon   = "mastodon"
+	mastodonSearchLimit = 40
+)
+
+// Busca de status via /api/v2/search de uma instancia (MASTODON_INSTANCE).
+// Sem MASTODON_TOKEN a maioria das instancias so devolve resultados locais
+type mastodonSource struct {
+	client   *rateLimitedClient
+	instance string
+	token    string
+}
+
+func newMastodonSource() (*mastodonSource, error) {
+	instance := strings.TrimSuffix(os.Getenv("MASTODON_INSTANCE"), "/")
+	if instance == "" {
+		return nil, fmt.Errorf("MASTODON_INSTANCE is not set")
+	}
+	if !strings.Contains(instance, "://") {
+		instance = "https://" + instance
+	}
+	return &mastodonSource{
+		client:   newRateLimitedClient(),
+		instance: instance,
+		token:    os.Getenv("MASTODON_TOKEN"),
+	}, nil
+}
+
+type mastodonStatus struct {
+	ID        string `json:"id"`
+	URI       string `json:"uri"`
+	URL       string `json:"url"`
+	CreatedAt string `json:"created_at"`
+	Content   string `json:"content"`
+	Language  string `json:"language"`
+	Account   struct {
+		ID          string `json:"id"`
+		Acct        string `json:"acct"`
+		DisplayName string `json:"display_name"`
+		URL         string `json:"url"`
+	} `json:"account"`
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	MediaAttachments []struct {
+		Description string `json:"description"`
+	} `json:"media_attachments"`
+	InReplyToID string `json:"in_reply_to_id"`
+}
+
+func (m *mastodonSource) Name() string { return "mastodon" }
+
+// O cursor e o offset da busca
+func (m *mastodonSource) Search(ctx context.Context, query string, cp *checkpoint, page func([]Post) error) error {
+	offset, _ := strconv.Atoi(cp.Cursor)
+	for cp.Retrieved < maxResults {
+		statuses, err := m.search(ctx, query, offset)
+		if err != nil {
+			return err
+		}
+		log.Printf("Mastodon: %d statuses, offset=%d, Total=%d", len(statuses), offset, cp.Retrieved)
+
+		// Cada status decodificado a partir dos bytes originais, que vao intactos para o posts_raw
+		posts := make([]Post, 0, len(statuses))
+		for _, raw := range statuses {
+			post, err := decodeMastodonStatus(raw)
+			if err != nil {
+				return fmt.Errorf("decode mastodon status: %w", err)
+			}
+			posts = append(posts, post)
+		}
+
+		offset += len(statuses)
+		cp.Cursor = strconv.Itoa(offset)
+		cp.Retrieved += len(statuses)
+
+		err = page(posts)
+		if err == errStopPaging {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(statuses) < mastodonSearchLimit {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *mastodonSource) Stream(ctx context.Context, cp *checkpoint, page func([]Post) error) error {
+	return fmt.Errorf("mastodon stream: %w", errUnsupported)
+}
+
+func (m *mastodonSource) search(ctx context.Context, query string, offset int) ([]json.RawMessage, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("type", "statuses")
+	params.Set("limit", strconv.Itoa(mastodonSearchLimit))
+	params.Set("offset", strconv.Itoa(offset))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", m.instance+"/api/v2/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mastodon search returned %s", resp.Status)
+	}
+
+	var result struct {
+		Statuses []json.RawMessage `json:"statuses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode mastodon search: %w", err)
+	}
+	return result.Statuses, nil
+}
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// O content do Mastodon e HTML
+func stripHTML(s string) string {
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// Converte o status no Post usado pelo resto do pipeline
+func (s mastodonStatus) post(raw []byte) Post {
+	var post Post
+	post.URI = s.URI
+	if post.URI == "" {
+		post.URI = s.URL
+	}
+	post.Author.DID = s.Account.URL
+	post.Author.Handle = s.Account.Acct
+	post.Author.DisplayName = s.Account.DisplayName
+	post.Record.Text = stripHTML(s.Content)
+	post.Record.CreatedAt = s.CreatedAt
+	if s.Language != "" {
+		post.Record.Langs = []string{s.Language}
+	}
+	for _, tag := range s.Tags {
+		post.Record.Tags = append(post.Record.Tags, tag.Name)
+	}
+
+	var images []embedImage
+	for _, media := range s.MediaAttachments {
+		images = append(images, embedImage{Alt: media.Description})
+	}
+	if len(images) > 0 {
+		post.Record.Embed = &postEmbed{Type: "app.bsky.embed.images", Images: images}
+	}
+
+	post.Raw, post.RawSource = raw, rawSourceMastodon
+	return post
+}
+
+func decodeMastodonStatus(data []byte) (Post, error) {
+	var status mastodonStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return Post{}, err
+	}
+	return status.post(data), nil
+}
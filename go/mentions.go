@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Trecho do post reconhecido como um medicamento da watchlist ou do dicionario.
+// Start/End sao offsets em bytes no texto do post
+type drugMention struct {
+	Drug  string `json:"drug" bson:"drug"`
+	Text  string `json:"text" bson:"text"`
+	Start int    `json:"start" bson:"start"`
+	End   int    `json:"end" bson:"end"`
+	Match string `json:"match" bson:"match"` // exact, ambiguous, fuzzy ou phonetic
+}
+
+type detectorTerm struct {
+	folded string
+	key    string
+	words  int
+	drug   string
+	// Nome que tambem e palavra comum (ambiguous na watchlist): so casa exato
+	exactOnly bool
+}
+
+// Palavras comuns que ficam a uma letra ou a mesma chave fonetica de um nome de
+// medicamento ("conserta"/Concerta, "atenta"/Atentah): nunca viram candidatas
+// no match aproximado
+var detectorStopWords = map[string]bool{
+	"atenta": true, "atento": true, "atentas": true, "atentos": true, "atende": true,
+	"conserta": true, "concerto": true, "conserto": true, "concierto": true,
+	"frontal": true, "pondera": true, "ponderar": true, "ciclo": true, "ciclos": true,
+}
+
+// Chave dos indices do detector: numero de palavras e a primeira letra (fuzzy)
+// ou a chave fonetica
+type detectorKey struct {
+	words int
+	key   string
+}
+
+var (
+	detectorOnce     sync.Once
+	detectorMaxWords int
+	// Indices para nao comparar cada n-grama com todos os termos: exato pelo
+	// nome, fuzzy so com os de mesma primeira letra, fonetico pela chave.
+	// Nomes exactOnly ficam so no exato
+	detectorExact    map[string]detectorTerm
+	detectorFuzzy    map[detectorKey][]detectorTerm
+	detectorPhonetic map[detectorKey]detectorTerm
+)
+
+// Todos os nomes da watchlist e do dicionario, apontando para o nome canonico
+func buildDetector() {
+	detectorExact = make(map[string]detectorTerm)
+	detectorFuzzy = make(map[detectorKey][]detectorTerm)
+	detectorPhonetic = make(map[detectorKey]detectorTerm)
+	add := func(name, drug string) {
+		// Mesmas palavras que o texto gera no splitWords ("+" e "/" nao contam)
+		var parts []string
+		for _, w := range splitWords(foldText(name)) {
+			parts = append(parts, w.text)
+		}
+		folded := strings.Join(parts, " ")
+		if _, ok := detectorExact[folded]; folded == "" || ok {
+			return
+		}
+		term := detectorTerm{folded: folded, key: phoneticKey(folded), words: len(strings.Fields(folded)), drug: drug, exactOnly: isAmbiguousName(name)}
+		detectorExact[folded] = term
+		detectorMaxWords = max(detectorMaxWords, term.words)
+		if term.exactOnly {
+			return
+		}
+		first := detectorKey{term.words, folded[:1]}
+		detectorFuzzy[first] = append(detectorFuzzy[first], term)
+		phonetic := detectorKey{term.words, term.key}
+		if _, ok := detectorPhonetic[phonetic]; !ok && len(term.key) >= 4 {
+			detectorPhonetic[phonetic] = term
+		}
+	}
+	for _, drug := range watchlist {
+		for _, term := range drug.terms() {
+			add(term, drug.Name)
+		}
+	}
+	for _, entry := range drugDictionary {
+		for _, name := range append([]string{entry.Name}, entry.Synonyms...) {
+			add(name, canonicalName(entry.Name))
+		}
+	}
+}
+
+// Chave fonetica grosseira para pt/es/en: une grafias equivalentes (ph/f, y/i,
+// c/s/k, z/s, qu/k), remove h mudo, letras dobradas e as vogais depois da primeira letra
+func phoneticKey(folded string) string {
+	s := strings.NewReplacer(
+		"ph", "f", "th", "t", "ch", "x", "sh", "x", "qu", "k", "ck", "k",
+		"ce", "se", "ci", "si", "y", "i", "w", "v", "z", "s", "h", "", " ", "",
+	).Replace(folded)
+	s = strings.ReplaceAll(s, "c", "k")
+
+	var key []rune
+	for i, r := range s {
+		if i > 0 && strings.ContainsRune("aeiou", r) {
+			continue
+		}
+		// m/n antes de consoante soam igual (Vemvanse/Venvanse)
+		if r == 'm' {
+			r = 'n'
+		}
+		if len(key) > 0 && key[len(key)-1] == r {
+			continue
+		}
+		key = append(key, r)
+	}
+	return string(key)
+}
+
+type textWord struct {
+	text       string
+	start, end int
+}
+
+func splitWords(text string) []textWord {
+	var words []textWord
+	start := -1
+	for i, r := range text {
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if letter && start < 0 {
+			start = i
+		} else if !letter && start >= 0 {
+			words = append(words, textWord{text[start:i], start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, textWord{text[start:], start, len(text)})
+	}
+	return words
+}
+
+// Procura nomes de medicamentos no texto: exato (sem acento/caixa), por distancia
+// de edicao e por chave fonetica. Trechos mais longos e exatos tem prioridade
+func detectDrugMentions(text string) []drugMention {
+	detectorOnce.Do(buildDetector)
+
+	words := splitWords(text)
+	used := make([]bool, len(words))
+	var mentions []drugMention
+
+	for _, match := range []string{"exact", "fuzzy", "phonetic"} {
+		for n := detectorMaxWords; n >= 1; n-- {
+			for i := 0; i+n <= len(words); i++ {
+				if anyUsed(used[i : i+n]) {
+					continue
+				}
+				parts := make([]string, n)
+				for k := 0; k < n; k++ {
+					parts[k] = foldText(words[i+k].text)
+				}
+				term, ok := matchDetectorTerm(strings.Join(parts, " "), n, match)
+				if !ok {
+					continue
+				}
+				start, end := words[i].start, words[i+n-1].end
+				mention := drugMention{Drug: term.drug, Text: text[start:end], Start: start, End: end, Match: match}
+				if term.exactOnly {
+					mention.Match = "ambiguous"
+				}
+				mentions = append(mentions, mention)
+				for k := i; k < i+n; k++ {
+					used[k] = true
+				}
+			}
+		}
+	}
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Start < mentions[j].Start })
+	return mentions
+}
+
+func anyUsed(used []bool) bool {
+	for _, u := range used {
+		if u {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDetectorTerm(folded string, words int, match string) (detectorTerm, bool) {
+	if folded == "" {
+		return detectorTerm{}, false
+	}
+	if match == "exact" {
+		term, ok := detectorExact[folded]
+		return term, ok
+	}
+	length := len([]rune(folded))
+	// Palavras curtas so casam exatas
+	if detectorStopWords[folded] || length < 7 {
+		return detectorTerm{}, false
+	}
+	switch match {
+	case "fuzzy":
+		// Erros de digitacao raramente trocam a primeira letra
+		limit := 1
+		if length >= 10 {
+			limit = 2
+		}
+		for _, term := range detectorFuzzy[detectorKey{words, folded[:1]}] {
+			if levenshtein(folded, term.folded) <= limit {
+				return term, true
+			}
+		}
+	case "phonetic":
+		term, ok := detectorPhonetic[detectorKey{words, phoneticKey(folded)}]
+		return term, ok
+	}
+	return detectorTerm{}, false
+}
+
+// Medicamentos encontrados no texto, para o LLM confirmar. Nomes ambiguos
+// ("dor frontal") ficam fora para nao induzir o LLM; servem so para conferir a resposta
+func drugMentionsPromptSection(mentions []drugMention) string {
+	var listed []drugMention
+	for _, m := range mentions {
+		if m.Match != "ambiguous" {
+			listed = append(listed, m)
+		}
+	}
+	if len(listed) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Possible medicine mentions found in the post (text -> medicine): ")
+	for i, m := range listed {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprintf("%q -> %s", m.Text, m.Drug))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// Marca os medicamentos do LLM que nao aparecem no texto (post e, quando enviado,
+// o texto do embed), nem pelo detector nem literalmente: provaveis alucinacoes.
+// mentions e o que o detector ja achou no post; so o embed e lido aqui
+func flagUnmentionedDrugs(analysis []Medication, mentions []drugMention, text, embed string) []Medication {
+	if embed != "" {
+		mentions = append(mentions[:len(mentions):len(mentions)], detectDrugMentions(embed)...)
+	}
+	found := make(map[string]bool)
+	for _, m := range mentions {
+		found[foldText(m.Drug)] = true
+	}
+	foldedText := foldText(text + "\n" + embed)
+
+	for i, med := range analysis {
+		if med.Name == "" || med.Name == "X" {
+			continue
+		}
+		if found[foldText(med.Name)] || found[foldText(canonicalName(med.Name))] {
+			continue
+		}
+		verbatim := med.Verbatim
+		if verbatim == "" {
+			verbatim = med.Name
+		}
+		if strings.Contains(foldedText, foldText(verbatim)) {
+			continue
+		}
+		analysis[i].NotInPost = true
+	}
+	return analysis
+}
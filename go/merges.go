@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	mergeProposed = "proposed"
+	mergeApplied  = "applied"
+	mergeRejected = "rejected"
+
+	embeddingBatch = 64
+)
+
+// Proposta de juntar Terms (quase sinonimos pelo embedding) em Canonical.
+// Aprovada, e aplicada aos posts, medications e ao vocabulario
+type mergeProposal struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Canonical  string             `bson:"canonical"`
+	Terms      []string           `bson:"terms"`
+	Similarity []float64          `bson:"similarity"` // de cada termo com o canonico
+	Status     string             `bson:"status"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ReviewedAt time.Time          `bson:"reviewed_at,omitempty"`
+}
+
+// Embeddings de um endpoint compativel com /v1/embeddings da OpenAI (llama.cpp local por padrao)
+func embedTexts(ctx context.Context, texts []string) ([][]float64, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	vectors := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatch {
+		batch := texts[start:min(start+embeddingBatch, len(texts))]
+		reqBody := map[string]interface{}{"input": batch}
+		if *embeddingsModelFlag != "" {
+			reqBody["model"] = *embeddingsModelFlag
+		}
+		jsonBody, _ := json.Marshal(reqBody)
+
+		req, err := http.NewRequestWithContext(ctx, "POST", *embeddingsURLFlag, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("embeddings request: %w", err)
+		}
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error,omitempty"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode embeddings response (%s): %w", resp.Status, err)
+		}
+		if result.Error != nil && result.Error.Message != "" {
+			return nil, fmt.Errorf("embeddings API returned error: %s", result.Error.Message)
+		}
+		if len(result.Data) != len(batch) {
+			return nil, fmt.Errorf("embeddings API returned %d vectors for %d inputs", len(result.Data), len(batch))
+		}
+		sort.Slice(result.Data, func(i, j int) bool { return result.Data[i].Index < result.Data[j].Index })
+		for _, d := range result.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	return vectors, nil
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// Agrupa os termos do vocabulario por similaridade com um lider: aprovados e mais
+// frequentes viram canonicos primeiro e puxam os termos com similaridade >= limiar.
+// Comparar sempre com o lider evita cadeias de termos cada vez mais distantes
+func clusterVocabulary(ctx context.Context) {
+	var terms []vocabularyTerm
+	for _, status := range []string{vocabularyApproved, vocabularyCandidate} {
+		loaded, err := loadVocabulary(status)
+		if err != nil {
+			log.Fatal(err)
+		}
+		terms = append(terms, loaded...)
+	}
+	if len(terms) < 2 {
+		log.Printf("Nothing to cluster: %d terms", len(terms))
+		return
+	}
+
+	texts := make([]string, len(terms))
+	for i, term := range terms {
+		texts[i] = term.Term
+	}
+	log.Printf("Embedding %d ADR terms with %s", len(texts), *embeddingsURLFlag)
+	vectors, err := embedTexts(ctx, texts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Pares ja propostos (em qualquer status) nao sao propostos de novo
+	existing := make(map[string]bool)
+	cur, err := mergesColl.Find(context.TODO(), bson.M{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var previous []mergeProposal
+	if err := cur.All(context.TODO(), &previous); err != nil {
+		log.Fatal(err)
+	}
+	for _, proposal := range previous {
+		for _, term := range proposal.Terms {
+			existing[proposal.Canonical+"\x00"+term] = true
+		}
+	}
+
+	assigned := make([]bool, len(terms))
+	proposed := 0
+	for i := range terms {
+		if assigned[i] {
+			continue
+		}
+		assigned[i] = true
+		proposal := mergeProposal{Canonical: terms[i].Term, Status: mergeProposed, CreatedAt: time.Now().UTC()}
+		for j := i + 1; j < len(terms); j++ {
+			if assigned[j] {
+				continue
+			}
+			similarity := cosine(vectors[i], vectors[j])
+			if similarity < *mergeThresholdFlag {
+				continue
+			}
+			assigned[j] = true
+			if !existing[terms[i].Term+"\x00"+terms[j].Term] {
+				proposal.Terms = append(proposal.Terms, terms[j].Term)
+				proposal.Similarity = append(proposal.Similarity, math.Round(similarity*1000)/1000)
+			}
+		}
+		if len(proposal.Terms) == 0 {
+			continue
+		}
+		if _, err := mergesColl.InsertOne(context.TODO(), proposal); err != nil {
+			log.Printf("Merge proposal save error: %v", err)
+			continue
+		}
+		proposed += 1
+		fmt.Printf("%s <- %s\n", proposal.Canonical, strings.Join(proposal.Terms, ", "))
+	}
+	log.Printf("%d merge proposals saved for review (./main merges)", proposed)
+}
+
+// merges [list [status]] | approve <id>... | reject <id>...
+func runMerges(args []string) {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "list":
+		status := mergeProposed
+		if len(args) > 0 {
+			status = args[0]
+		}
+		cur, err := mergesColl.Find(context.TODO(), bson.M{"status": status})
+		if err != nil {
+			log.Fatal(err)
+		}
+		var proposals []mergeProposal
+		if err := cur.All(context.TODO(), &proposals); err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range proposals {
+			fmt.Printf("%s  %s <- %s %v\n", p.ID.Hex(), p.Canonical, strings.Join(p.Terms, ", "), p.Similarity)
+		}
+		log.Printf("%d %s merges", len(proposals), status)
+
+	case "approve", "reject":
+		for _, hex := range args {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				log.Printf("%s: invalid id", hex)
+				continue
+			}
+			var proposal mergeProposal
+			if err := mergesColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&proposal); err != nil {
+				log.Printf("%s: %v", hex, err)
+				continue
+			}
+			if proposal.Status != mergeProposed {
+				log.Printf("%s: already %s", hex, proposal.Status)
+				continue
+			}
+
+			status := mergeRejected
+			if action == "approve" {
+				if err := applyMerge(proposal); err != nil {
+					log.Printf("%s: apply failed, left as proposed: %v", hex, err)
+					continue
+				}
+				status = mergeApplied
+			}
+			_, err = mergesColl.UpdateOne(context.TODO(), bson.M{"_id": id},
+				bson.M{"$set": bson.M{"status": status, "reviewed_at": time.Now().UTC()}})
+			if err != nil {
+				log.Printf("%s: %v", hex, err)
+				continue
+			}
+			log.Printf("%s: %s <- %s %s", hex, proposal.Canonical, strings.Join(proposal.Terms, ", "), status)
+		}
+
+	default:
+		log.Fatalf("unknown merges action %q (list, approve or reject)", action)
+	}
+}
+
+// Reescreve retroativamente os termos juntados: ADRs dos posts (e a gravidade
+// deles), listas de medications e o vocabulario (os termos juntados ficam
+// rejeitados com merged_into). Pode ser repetido depois de uma falha: as
+// frequencias so passam para o canonico uma vez por proposta
+func applyMerge(proposal mergeProposal) error {
+	ctx := context.TODO()
+	terms, canonical := proposal.Terms, proposal.Canonical
+
+	var target vocabularyTerm
+	if err := vocabularyColl.FindOne(ctx, bson.M{"term": canonical}).Decode(&target); err != nil {
+		return fmt.Errorf("canonical term %s: %w", canonical, err)
+	}
+
+	// ADRs codificadas com um dos termos, ou sem codigo e com o texto igual a um deles
+	set := bson.M{
+		"analysis.$[m].adrs.$[a].term":  canonical,
+		"analysis.$[m].adrs.$[a].match": "merge",
+	}
+	if target.SOC != "" {
+		set["analysis.$[m].adrs.$[a].soc"] = target.SOC
+	}
+	filters := options.ArrayFilters{Filters: []interface{}{
+		bson.M{"m.adrs": bson.M{"$type": "array"}},
+		bson.M{"$or": bson.A{
+			bson.M{"a.term": bson.M{"$in": terms}},
+			bson.M{"a.term": bson.M{"$exists": false}, "a.verbatim": bson.M{"$in": terms}},
+		}},
+	}}
+	result, err := postsColl.UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"analysis.adrs.term": bson.M{"$in": terms}},
+			bson.M{"analysis.adrs.verbatim": bson.M{"$in": terms}},
+		}},
+		bson.M{"$set": set},
+		options.Update().SetArrayFilters(filters))
+	if err != nil {
+		return fmt.Errorf("posts: %w", err)
+	}
+	log.Printf("Merge %s: %d posts updated", canonical, result.ModifiedCount)
+	if err := regradeMergedPosts(canonical); err != nil {
+		return fmt.Errorf("posts: %w", err)
+	}
+
+	// Todas as listas de termos de medications; $addToSet e $pull no mesmo campo
+	// nao podem ir na mesma operacao
+	fields := []string{"adrs", "firstHandAdrs", "seriousAdrs"}
+	for _, relation := range []string{relationIndication, relationWithdrawal, relationBeneficial, relationNoEffect, relationOther} {
+		fields = append(fields, effectsKey(relation))
+	}
+	updated := int64(0)
+	for _, field := range fields {
+		medFilter := bson.M{field: bson.M{"$in": terms}}
+		if _, err := medicationsColl.UpdateMany(ctx, medFilter, bson.M{"$addToSet": bson.M{field: canonical}}); err != nil {
+			return fmt.Errorf("medications %s: %w", field, err)
+		}
+		result, err = medicationsColl.UpdateMany(ctx, medFilter, bson.M{"$pull": bson.M{field: bson.M{"$in": terms}}})
+		if err != nil {
+			return fmt.Errorf("medications %s: %w", field, err)
+		}
+		updated += result.ModifiedCount
+	}
+	log.Printf("Merge %s: %d medication lists updated", canonical, updated)
+
+	// Frequencias e sinonimos dos termos juntados passam para o canonico
+	cur, err := vocabularyColl.Find(ctx, bson.M{"term": bson.M{"$in": terms}})
+	if err != nil {
+		return fmt.Errorf("vocabulary: %w", err)
+	}
+	var merged []vocabularyTerm
+	if err := cur.All(ctx, &merged); err != nil {
+		return fmt.Errorf("vocabulary: %w", err)
+	}
+	inc := bson.M{}
+	synonyms := append([]string(nil), terms...)
+	for _, term := range merged {
+		inc["frequency"] = toInt(inc["frequency"]) + term.Frequency
+		for drug, n := range term.Drugs {
+			inc["drugs."+drug] = toInt(inc["drugs."+drug]) + n
+		}
+		synonyms = append(synonyms, term.Synonyms...)
+	}
+	// A proposta fica registrada no canonico junto com o $inc; numa repeticao o
+	// filtro nao casa e as frequencias nao sao somadas de novo
+	update := bson.M{"$addToSet": bson.M{"synonyms": bson.M{"$each": synonyms}, "applied_merges": proposal.ID}}
+	if len(inc) > 0 {
+		update["$inc"] = inc
+	}
+	if _, err := vocabularyColl.UpdateOne(ctx, bson.M{"term": canonical, "applied_merges": bson.M{"$ne": proposal.ID}}, update); err != nil {
+		return fmt.Errorf("vocabulary: %w", err)
+	}
+	_, err = vocabularyColl.UpdateMany(ctx, bson.M{"term": bson.M{"$in": terms}},
+		bson.M{"$set": bson.M{"status": vocabularyRejected, "merged_into": canonical}})
+	if err != nil {
+		return fmt.Errorf("vocabulary: %w", err)
+	}
+	// Termos juntados antes num dos termos de agora passam a apontar para o canonico
+	_, err = vocabularyColl.UpdateMany(ctx, bson.M{"merged_into": bson.M{"$in": terms}},
+		bson.M{"$set": bson.M{"merged_into": canonical}})
+	if err != nil {
+		return fmt.Errorf("vocabulary: %w", err)
+	}
+	return nil
+}
+
+// Recalcula DME e gravidade dos posts com ADRs juntadas em canonical: o termo
+// novo pode ser um evento medico designado
+func regradeMergedPosts(canonical string) error {
+	ctx := context.TODO()
+	cur, err := postsColl.Find(ctx,
+		bson.M{"analysis.adrs": bson.M{"$elemMatch": bson.M{"term": canonical, "match": "merge"}}},
+		options.Find().SetProjection(bson.M{"analysis": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	regraded := 0
+	for cur.Next(ctx) {
+		var doc struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Analysis []Medication       `bson:"analysis"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			log.Printf("Merge %s: post decode error: %v", canonical, err)
+			continue
+		}
+		doc.Analysis = gradeSeriousness(doc.Analysis)
+		serious, criteria, severity := postSeriousness(doc.Analysis)
+		_, err := postsColl.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{
+			"analysis":     doc.Analysis,
+			"serious":      serious,
+			"seriousness":  criteria,
+			"max_severity": severity,
+		}})
+		if err != nil {
+			return err
+		}
+		regraded++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	log.Printf("Merge %s: seriousness recomputed for %d posts", canonical, regraded)
+	return nil
+}
+
+func toInt(v interface{}) int {
+	n, _ := v.(int)
+	return n
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Item do pipeline: um post para analisar ou um marcador (after) que roda no
+// writer depois que tudo enfileirado antes dele foi gravado
+type postJob struct {
+	seq    int
+	post   Post
+	query  string
+	stored bool
+	after  func()
+}
+
+type postResult struct {
+	postJob
+	thread   *threadContext
+	answer   string
+	analysis []Medication
+	mentions []drugMention
+	// Resposta do LLM que nao deu para ler (JSON invalido ou cortado)
+	parseError string
+	// Cancelado ou sem resposta do LLM: nao e gravado e volta na proxima execucao
+	canceled bool
+}
+
+// Busca -> workers (LLM) -> writer em lotes, ligados por canais com buffer.
+// O writer reordena pelo seq, entao a gravacao segue a ordem da busca
+type pipeline struct {
+	// ctx para a busca e o inicio de novas analises; llmCtx so e cancelado
+	// -shutdown-grace depois, para as chamadas em andamento terminarem
+	ctx        context.Context
+	llmCtx     context.Context
+	cancelLLM  context.CancelFunc
+	jobs       chan postJob
+	results    chan postResult
+	out        *json.Encoder
+	seq        int
+	workers    sync.WaitGroup
+	writerDone chan struct{}
+
+	// Posts enfileirados e ainda nao gravados (a mesma busca pode repetir posts entre queries)
+	queuedMu   sync.Mutex
+	queuedURIs map[string]bool
+
+	submitted  int
+	written    int
+	dropped    int
+	incomplete bool
+}
+
+// Com out != nil os resultados sao escritos como JSONL em vez de gravados no MongoDB
+func newPipeline(ctx context.Context, workers, batchSize int, out io.Writer) *pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	llmCtx, cancelLLM := context.WithCancel(context.Background())
+	p := &pipeline{
+		ctx:        ctx,
+		llmCtx:     llmCtx,
+		cancelLLM:  cancelLLM,
+		jobs:       make(chan postJob, workers*2),
+		results:    make(chan postResult, workers*2),
+		writerDone: make(chan struct{}),
+		queuedURIs: make(map[string]bool),
+	}
+	if out != nil {
+		p.out = json.NewEncoder(out)
+	}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.worker()
+	}
+	go p.writer(batchSize)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sleepContext(llmCtx, *shutdownGraceFlag)
+			cancelLLM()
+		case <-llmCtx.Done():
+		}
+	}()
+	return p
+}
+
+// Enfileira um post; bloqueia quando os workers estao ocupados.
+// Chamado sempre pela mesma goroutine (etapa de busca)
+func (p *pipeline) submit(post Post, query string, stored bool) error {
+	p.queuedMu.Lock()
+	p.queuedURIs[post.URI] = true
+	p.queuedMu.Unlock()
+	return p.enqueue(postJob{post: post, query: query, stored: stored})
+}
+
+func (p *pipeline) queued(uri string) bool {
+	p.queuedMu.Lock()
+	defer p.queuedMu.Unlock()
+	return p.queuedURIs[uri]
+}
+
+func (p *pipeline) release(uri string) {
+	p.queuedMu.Lock()
+	delete(p.queuedURIs, uri)
+	p.queuedMu.Unlock()
+}
+
+func (p *pipeline) after(fn func()) error {
+	return p.enqueue(postJob{after: fn})
+}
+
+func (p *pipeline) enqueue(job postJob) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	job.seq = p.seq
+	select {
+	case p.jobs <- job:
+		p.seq++
+		if job.after == nil {
+			p.submitted++
+		}
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// Fecha a entrada e espera workers e writer terminarem
+func (p *pipeline) close() {
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.results)
+	<-p.writerDone
+	p.cancelLLM()
+}
+
+// Resumo impresso ao final de cada comando
+func (p *pipeline) summary(start time.Time) string {
+	status := "finished"
+	if p.ctx.Err() != nil {
+		status = "interrupted"
+	}
+	return fmt.Sprintf("Run %s after %s: %d posts queued, %d analyzed and stored, %d dropped (will be fetched again)",
+		status, time.Since(start).Round(time.Second), p.submitted, p.written, p.dropped)
+}
+
+func (p *pipeline) worker() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		if job.after != nil {
+			p.results <- postResult{postJob: job}
+			continue
+		}
+		if p.ctx.Err() != nil {
+			p.results <- postResult{postJob: job, canceled: true}
+			continue
+		}
+		p.results <- analyzePost(p.llmCtx, job)
+	}
+}
+
+func (p *pipeline) writer(batchSize int) {
+	defer close(p.writerDone)
+
+	pending := make(map[int]postResult)
+	next := 0
+	var batch []postResult
+
+	flush := func() {
+		if len(batch) > 0 {
+			p.writeBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	emit := func(r postResult) {
+		if r.after != nil {
+			flush()
+			// Depois de um post cancelado o progresso salvo ficaria a frente do gravado
+			if !p.incomplete {
+				r.after()
+			}
+			return
+		}
+		if r.canceled {
+			p.release(r.post.URI)
+			p.dropped++
+			p.incomplete = true
+			return
+		}
+		batch = append(batch, r)
+		if len(batch) >= batchSize {
+			flush()
+		}
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-p.results:
+			if !ok {
+				seqs := make([]int, 0, len(pending))
+				for seq := range pending {
+					seqs = append(seqs, seq)
+				}
+				sort.Ints(seqs)
+				for _, seq := range seqs {
+					emit(pending[seq])
+				}
+				flush()
+				return
+			}
+			pending[r.seq] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				emit(r)
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Grava um lote: medicamentos, vocabulario de ADRs, JSON original e posts, nessa ordem
+func (p *pipeline) writeBatch(batch []postResult) {
+	if p.out != nil {
+		p.writeJSONL(batch)
+		return
+	}
+
+	var medicationUpdates, vocabularyUpdates, rawUpdates, postWrites []mongo.WriteModel
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+
+	for _, r := range batch {
+		medicationUpdates = append(medicationUpdates, medicationModels(r.analysis, r.stored)...)
+		vocabularyUpdates = append(vocabularyUpdates, vocabularyModels(r.analysis, r.stored)...)
+		if model := rawPostModel(r.post); model != nil {
+			rawUpdates = append(rawUpdates, model)
+		}
+
+		document := postFields(r.post, r.thread)
+		document["query"] = r.query
+		document["rawOutput"] = r.answer
+		document["analysis"] = r.analysis
+		if len(r.mentions) > 0 {
+			document["drug_mentions"] = r.mentions
+		}
+		// Fica gravado para achar e refazer com --reanalyze
+		document["parse_error"] = r.parseError
+		serious, criteria, severity := postSeriousness(r.analysis)
+		document["serious"] = serious
+		document["seriousness"] = criteria
+		document["max_severity"] = severity
+
+		if r.stored {
+			document["reanalyzed_at"] = now
+			postWrites = append(postWrites, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"post_uri": r.post.URI}).
+				SetUpdate(bson.M{"$set": document}))
+		} else {
+			document["indexed_at"] = now
+			postWrites = append(postWrites, mongo.NewInsertOneModel().SetDocument(document))
+		}
+	}
+
+	if len(medicationUpdates) > 0 {
+		if _, err := medicationsColl.BulkWrite(context.TODO(), medicationUpdates); err != nil {
+			log.Printf("Medication update error: %v", err)
+		}
+	}
+	if len(vocabularyUpdates) > 0 {
+		if _, err := vocabularyColl.BulkWrite(context.TODO(), vocabularyUpdates, options.BulkWrite().SetOrdered(false)); err != nil {
+			log.Printf("ADR vocabulary update error: %v", err)
+		}
+	}
+	if len(rawUpdates) > 0 {
+		if _, err := rawPostsColl.BulkWrite(context.TODO(), rawUpdates, options.BulkWrite().SetOrdered(false)); err != nil {
+			log.Printf("Raw post save error: %v", err)
+		}
+	}
+	if len(postWrites) > 0 {
+		if _, err := postsColl.BulkWrite(context.TODO(), postWrites, options.BulkWrite().SetOrdered(false)); err != nil {
+			log.Printf("Insert error: %v", err)
+		}
+	}
+
+	print(fmt.Sprintf("\n***\nADRs Lista: %s\n***\n", adrReference("")))
+	for _, r := range batch {
+		p.release(r.post.URI)
+		p.written += 1
+		fmt.Printf("Posts analisados: %d\n---\n\n", p.written)
+
+		print(fmt.Sprintf(`Usuario: %s
+
+  `, r.post.Author.DisplayName))
+		print(fmt.Sprintf(`Texto: %s
+
+  `, r.post.Record.Text))
+		print(fmt.Sprintf(`Output: %s
+  `, r.answer))
+		print(fmt.Sprintf(`Analise: %v
+
+  ---
+
+  `, r.analysis))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type llmProvider struct {
+	generate func(ctx context.Context, prompt string) (string, error)
+	slots    chan struct{}
+}
+
+// Limite padrao de chamadas simultaneas por provedor (-concurrency sobrescreve)
+var llmProviders = map[string]*llmProvider{
+	"deepseek":   {generate: generateTextDeepSeek, slots: make(chan struct{}, 4)},
+	"openrouter": {generate: generateTextOpenRouter, slots: make(chan struct{}, 8)},
+	"openai":     {generate: generateTextOpenAI, slots: make(chan struct{}, 8)},
+	"local":      {generate: generateTextLocalLLM, slots: make(chan struct{}, 1)},
+	"umbrella":   {generate: generateTextUmbrella, slots: make(chan struct{}, 1)},
+}
+
+func configureProviders() error {
+	if _, ok := llmProviders[*providerFlag]; !ok {
+		return fmt.Errorf("unknown provider %q", *providerFlag)
+	}
+	if *concurrencyFlag == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(*concurrencyFlag, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		provider, known := llmProviders[name]
+		limit, err := strconv.Atoi(value)
+		if !ok || !known || err != nil || limit < 1 {
+			return fmt.Errorf("invalid -concurrency entry %q", entry)
+		}
+		provider.slots = make(chan struct{}, limit)
+	}
+	return nil
+}
+
+// Chama o provedor escolhido com -provider, respeitando o limite dele
+func generateText(ctx context.Context, prompt string) (string, error) {
+	provider := llmProviders[*providerFlag]
+	select {
+	case provider.slots <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-provider.slots }()
+
+	return provider.generate(ctx, prompt)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Origem do JSON guardado em posts_raw (mais rawSourceMastodon, rawSourceReddit e rawSourceFile)
+const (
+	rawSourceView      = "app.bsky.feed.defs#postView"
+	rawSourceJetstream = "jetstream"
+)
+
+type rawPost struct {
+	URI    string `bson:"post_uri"`
+	Source string `bson:"source"`
+	Data   []byte `bson:"data"`
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// Upsert do JSON original comprimido; a versao mais recente substitui a anterior
+func rawPostModel(post Post) mongo.WriteModel {
+	if len(post.Raw) == 0 {
+		return nil
+	}
+	data, err := gzipBytes(post.Raw)
+	if err != nil {
+		log.Printf("Raw post compression error: %v", err)
+		return nil
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	update := bson.M{
+		"$set": bson.M{
+			"source":     post.RawSource,
+			"data":       data,
+			"fetched_at": now,
+		},
+		"$setOnInsert": bson.M{"first_fetched_at": now},
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"post_uri": post.URI}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
+
+func decodeRawPost(raw rawPost) (Post, error) {
+	data, err := gunzipBytes(raw.Data)
+	if err != nil {
+		return Post{}, fmt.Errorf("decompress: %w", err)
+	}
+
+	switch raw.Source {
+	case rawSourceJetstream:
+		var event jetstreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return Post{}, err
+		}
+		if event.Commit == nil {
+			return Post{}, fmt.Errorf("jetstream event without commit")
+		}
+		event.raw = data
+		return event.post()
+	case rawSourceMastodon:
+		return decodeMastodonStatus(data)
+	case rawSourceReddit:
+		return decodeRedditItem(data)
+	case rawSourceFile:
+		// A URI gerada pela posicao no arquivo nao esta no registro
+		post, err := decodeFileRecord(data)
+		post.URI = raw.URI
+		return post, err
+	default:
+		var post Post
+		err := json.Unmarshal(data, &post)
+		return post, err
+	}
+}
+
+// Refaz os campos derivados dos posts a partir do JSON guardado, sem chamar o LLM
+func reprocessRawPosts() {
+	cur, err := rawPostsColl.Find(context.TODO(), bson.M{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cur.Close(context.TODO())
+
+	updated, failed := 0, 0
+	for cur.Next(context.TODO()) {
+		var raw rawPost
+		if err := cur.Decode(&raw); err != nil {
+			log.Printf("Raw post decode error: %v", err)
+			failed += 1
+			continue
+		}
+		post, err := decodeRawPost(raw)
+		if err != nil {
+			log.Printf("Reprocess error for %s: %v", raw.URI, err)
+			failed += 1
+			continue
+		}
+
+		var thread *threadContext
+		if reply := post.Record.Reply; reply != nil {
+			thread = &threadContext{RootURI: reply.Root.URI, ParentURI: reply.Parent.URI}
+		}
+		fields := postFields(post, thread)
+		fields["reprocessed_at"] = primitive.NewDateTimeFromTime(time.Now().UTC())
+
+		res, err := postsColl.UpdateOne(context.TODO(), bson.M{"post_uri": raw.URI}, bson.M{"$set": fields})
+		if err != nil {
+			log.Printf("Reprocess update error for %s: %v", raw.URI, err)
+			failed += 1
+			continue
+		}
+		updated += int(res.ModifiedCount)
+	}
+	if err := cur.Err(); err != nil {
+		log.Printf("Raw posts cursor error: %v", err)
+	}
+	log.Printf("Reprocess finished: %d posts updated, %d failed", updated, failed)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const rawSourceReddit = "reddit"
+
+// Submissao (RS_*) ou comentario (RC_*) de um dump do Pushshift
+type redditItem struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Author     string      `json:"author"`
+	Subreddit  string      `json:"subreddit"`
+	Title      string      `json:"title"`
+	Selftext   string      `json:"selftext"`
+	Body       string      `json:"body"`
+	Permalink  string      `json:"permalink"`
+	LinkID     string      `json:"link_id"`
+	CreatedUTC json.Number `json:"created_utc"`
+}
+
+func (r redditItem) text() string {
+	body := r.Body
+	if r.Title != "" {
+		body = r.Selftext
+	}
+	if body == "[deleted]" || body == "[removed]" {
+		body = ""
+	}
+	return strings.TrimSpace(strings.TrimSpace(r.Title) + "\n\n" + body)
+}
+
+// Converte o item no Post usado pelo resto do pipeline
+func (r redditItem) post(raw []byte) Post {
+	var post Post
+	switch {
+	case r.Permalink != "":
+		post.URI = "https://www.reddit.com" + r.Permalink
+	case r.Name != "":
+		post.URI = "reddit:" + r.Name
+	default:
+		post.URI = "reddit:" + r.ID
+	}
+	post.Author.DID = "reddit:" + r.Author
+	post.Author.Handle = r.Author
+	post.Record.Text = r.text()
+	if secs, err := r.CreatedUTC.Float64(); err == nil && secs > 0 {
+		post.Record.CreatedAt = time.Unix(int64(secs), 0).UTC().Format(time.RFC3339)
+	}
+	if r.Subreddit != "" {
+		post.Record.Tags = []string{"r/" + r.Subreddit}
+	}
+	post.Raw, post.RawSource = raw, rawSourceReddit
+	return post
+}
+
+// Descarta itens apagados ou removidos, que ficam sem texto
+func decodeRedditRecord(data []byte) (Post, bool, error) {
+	var item redditItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Post{}, false, err
+	}
+	if item.Author == "[deleted]" && item.text() == "" {
+		return Post{}, false, nil
+	}
+	post := item.post(data)
+	return post, post.Record.Text != "", nil
+}
+
+// Usado pelo reprocess: o item ja foi aceito quando foi gravado
+func decodeRedditItem(data []byte) (Post, error) {
+	var item redditItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Post{}, err
+	}
+	return item.post(data), nil
+}
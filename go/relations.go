@@ -0,0 +1,64 @@
+package main
+
+import "strings"
+
+// Relacao entre o medicamento e o efeito citado. So adverse_reaction entra
+// nos agregados de ADR (medications.adrs, vocabulario, mentionCount)
+const (
+	relationAdverse    = "adverse_reaction"
+	relationIndication = "indication"
+	relationWithdrawal = "withdrawal"
+	relationBeneficial = "beneficial"
+	relationNoEffect   = "lack_of_efficacy"
+	// Relacao que o LLM inventou; o valor original fica no post
+	relationOther = "other"
+)
+
+var relationAliases = map[string]string{
+	"adr":                    relationAdverse,
+	"adverse":                relationAdverse,
+	"adverse_effect":         relationAdverse,
+	"side_effect":            relationAdverse,
+	"treated_symptom":        relationIndication,
+	"treatment":              relationIndication,
+	"discontinuation":        relationWithdrawal,
+	"withdrawal_effect":      relationWithdrawal,
+	"discontinuation_effect": relationWithdrawal,
+	"benefit":                relationBeneficial,
+	"beneficial_effect":      relationBeneficial,
+	"therapeutic_effect":     relationBeneficial,
+	"ineffective":            relationNoEffect,
+	"no_effect":              relationNoEffect,
+	"lack_of_effect":         relationNoEffect,
+}
+
+// Sem relacao na resposta vale adverse_reaction (a lista sempre foi de ADRs);
+// valores desconhecidos ficam como vieram e nao contam como ADR
+func normalizeRelation(relation string) string {
+	key := strings.Join(strings.Fields(strings.ToLower(strings.NewReplacer("-", " ", "/", " ").Replace(relation))), "_")
+	switch key {
+	case "":
+		return relationAdverse
+	case relationAdverse, relationIndication, relationWithdrawal, relationBeneficial, relationNoEffect:
+		return key
+	}
+	if alias, ok := relationAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// Chave em medications.effects: so relacoes conhecidas viram caminho de campo
+// (um valor com "." ou "$" quebraria o BulkWrite do lote inteiro)
+func effectsKey(relation string) string {
+	switch relation {
+	case relationIndication, relationWithdrawal, relationBeneficial, relationNoEffect:
+		return "effects." + relation
+	}
+	return "effects." + relationOther
+}
+
+// Analises gravadas antes da relacao existir so tinham ADRs
+func (m adrMention) isADR() bool {
+	return m.Relation == "" || m.Relation == relationAdverse
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Intensidade da ADR
+const (
+	severityMild     = "mild"
+	severityModerate = "moderate"
+	severitySevere   = "severe"
+)
+
+// Criterios de gravidade da ICH E2A
+var seriousnessCriteria = []string{
+	"death",
+	"life_threatening",
+	"hospitalization",
+	"disability",
+	"congenital_anomaly",
+	"medically_important",
+}
+
+// Evento medico designado (DME): grave por si so, qualquer que seja o desfecho
+type designatedEvent struct {
+	Term     string   `json:"term"`
+	Synonyms []string `json:"synonyms"`
+}
+
+// Nomes (sem acento, minusculas) dos DMEs -> termo do DME
+var designatedEvents map[string]string
+
+func loadDesignatedEvents(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var events []designatedEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	designatedEvents = make(map[string]string)
+	for _, event := range events {
+		if event.Term == "" {
+			continue
+		}
+		for _, name := range append([]string{event.Term}, event.Synonyms...) {
+			designatedEvents[foldText(strings.TrimSpace(name))] = event.Term
+		}
+	}
+	log.Printf("Designated medical events: %d events, %d names", len(events), len(designatedEvents))
+	return nil
+}
+
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case severityMild, "light", "low":
+		return severityMild
+	case severityModerate, "medium":
+		return severityModerate
+	case severitySevere, "serious", "high", "intense":
+		return severitySevere
+	}
+	return ""
+}
+
+// Mantem so criterios conhecidos, sem repetir, na ordem da ICH E2A
+func normalizeSeriousness(criteria []string) []string {
+	seen := make(map[string]bool)
+	for _, c := range criteria {
+		key := strings.Join(strings.Fields(strings.ToLower(strings.NewReplacer("-", " ").Replace(c))), "_")
+		switch key {
+		case "hospitalisation", "hospitalized", "prolonged_hospitalization":
+			key = "hospitalization"
+		case "life_threatening_event":
+			key = "life_threatening"
+		case "incapacity", "persistent_disability":
+			key = "disability"
+		case "birth_defect":
+			key = "congenital_anomaly"
+		case "important_medical_event", "medically_significant":
+			key = "medically_important"
+		}
+		seen[key] = true
+	}
+	var normalized []string
+	for _, c := range seriousnessCriteria {
+		if seen[c] {
+			normalized = append(normalized, c)
+		}
+	}
+	return normalized
+}
+
+// Normaliza severidade e criterios vindos do LLM e confere a ADR contra a
+// lista de DMEs (termo codificado ou texto original); DME conta como
+// medically_important. Roda depois da codificacao na terminologia
+func gradeSeriousness(analysis []Medication) []Medication {
+	for i := range analysis {
+		for j := range analysis[i].ADRs {
+			adr := &analysis[i].ADRs[j]
+			adr.Severity = normalizeSeverity(adr.Severity)
+			criteria := adr.Seriousness
+			for _, name := range []string{adr.Term, adr.Verbatim} {
+				if event, ok := designatedEvents[foldText(strings.TrimSpace(name))]; ok && name != "" {
+					adr.DME = event
+					criteria = append(criteria, "medically_important")
+					break
+				}
+			}
+			adr.Seriousness = normalizeSeriousness(criteria)
+			adr.Serious = len(adr.Seriousness) > 0
+		}
+	}
+	return analysis
+}
+
+// Resumo gravado no post para triagem: se alguma ADR contada e grave, os
+// criterios atingidos e a maior severidade
+func postSeriousness(analysis []Medication) (bool, []string, string) {
+	var criteria []string
+	severity := ""
+	rank := map[string]int{"": 0, severityMild: 1, severityModerate: 2, severitySevere: 3}
+	for _, med := range analysis {
+		for _, adr := range med.ADRs {
+			if !adr.counted() {
+				continue
+			}
+			criteria = append(criteria, adr.Seriousness...)
+			if rank[adr.Severity] > rank[severity] {
+				severity = adr.Severity
+			}
+		}
+	}
+	criteria = normalizeSeriousness(criteria)
+	return len(criteria) > 0, criteria, severity
+}
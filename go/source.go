@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	errStopPaging  = errors.New("stop paging")
+	errUnsupported = errors.New("not supported by this source")
+)
+
+// Fonte de posts. As implementacoes convertem o que leem para Post e entregam
+// paginas a page; o cursor proprio de cada fonte vai em cp.Cursor antes de cada
+// pagina, para o checkpoint ser salvo junto com ela
+type Source interface {
+	Name() string
+	// Posts de uma query
+	Search(ctx context.Context, query string, cp *checkpoint, page func([]Post) error) error
+	// Posts continuamente (ou ate o fim dos arquivos), atribuidos a uma query pelo texto
+	Stream(ctx context.Context, cp *checkpoint, page func([]Post) error) error
+}
+
+func newSource(name string, files []string) (Source, error) {
+	switch name {
+	case "bluesky":
+		return newBlueskySource(*incrementalFlag), nil
+	case "mastodon":
+		return newMastodonSource()
+	case "reddit", "file":
+		if len(files) == 0 {
+			return nil, fmt.Errorf("source %s needs at least one file argument", name)
+		}
+		if name == "reddit" {
+			return &fileSource{name: name, files: files, decode: decodeRedditRecord}, nil
+		}
+		return &fileSource{name: name, files: files}, nil
+	}
+	return nil, fmt.Errorf("unknown source %q", name)
+}
+
+// O BlueSky mantem os nomes de checkpoint de antes das outras fontes
+func checkpointMode(src Source, mode string) string {
+	if src.Name() == "bluesky" {
+		return mode
+	}
+	return src.Name() + ":" + mode
+}
+
+func streamCheckpointName(src Source) string {
+	if src.Name() == "bluesky" {
+		return "jetstream"
+	}
+	return src.Name()
+}
+
+// Fontes paginadas param em maxResults por query; as de arquivo leem tudo,
+// entao cp.Retrieved delas nao indica query concluida
+func pageLimited(src Source) bool {
+	_, ok := src.(*fileSource)
+	return !ok
+}
+
+func fixedQuery(query string) func(Post) (string, bool) {
+	return func(Post) (string, bool) { return query, true }
+}
+
+// Query definida pela fonte ou, se nao houver, o medicamento citado no texto
+func matchedQuery(post Post) (string, bool) {
+	if post.Query != "" {
+		return canonicalName(post.Query), true
+	}
+	return matchQuery(queryMatchers(), post.Record.Text)
+}
+
+// Envia uma pagina ao pipeline: descarta posts sem query, pula os ja gravados ou
+// enfileirados e agenda o checkpoint para depois que a pagina for gravada.
+// Com stopAtKnown devolve errStopPaging ao encontrar um post ja gravado
+func submitPage(p *pipeline, cp *checkpoint, posts []Post, queryFor func(Post) (string, bool), stopAtKnown bool) error {
+	var matched []Post
+	var queries []string
+	for _, post := range posts {
+		if query, ok := queryFor(post); ok {
+			matched = append(matched, post)
+			queries = append(queries, query)
+		}
+	}
+
+	cp.Total += len(matched)
+
+	// Uma consulta por pagina para saber quais posts ja foram analisados
+	known, err := knownPostURIs(matched)
+	if err != nil {
+		log.Printf("Known posts lookup error: %v", err)
+	}
+
+	reachedKnown := false
+	if stopAtKnown {
+		for i, post := range matched {
+			if known[post.URI] {
+				log.Printf("Reached already stored post %s, stopping", post.URI)
+				matched, reachedKnown = matched[:i], true
+				break
+			}
+		}
+	}
+
+	skipped := 0
+	for i, post := range matched {
+		if !p.queued(post.URI) && (!known[post.URI] || *reanalyzeFlag) {
+			if err := p.submit(post, queries[i], known[post.URI]); err != nil {
+				return err
+			}
+		} else {
+			skipped += 1
+		}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d already stored posts (use --reanalyze to analyze them again)", skipped)
+	}
+
+	snapshot := *cp
+	if err := p.after(func() { saveCheckpoint(&snapshot) }); err != nil {
+		return err
+	}
+	if reachedKnown {
+		return errStopPaging
+	}
+	return nil
+}
+
+// Termos buscados para um medicamento. Arquivos sao lidos inteiros e reconhecem
+// todos os nomes pelo texto, entao basta uma passada pelo nome canonico
+func searchTerms(src Source, drug watchlistDrug) []string {
+	if _, ok := src.(*fileSource); ok {
+		return []string{drug.Name}
+	}
+	return drug.queries()
+}
+
+// Search das fontes que so sabem ler tudo: percorre o Stream e fica com a query pedida
+func searchByStream(src Source, ctx context.Context, query string, cp *checkpoint, page func([]Post) error) error {
+	return src.Stream(ctx, cp, func(posts []Post) error {
+		var matched []Post
+		for _, post := range posts {
+			if q, ok := matchedQuery(post); ok && q == canonicalName(query) {
+				matched = append(matched, post)
+			}
+		}
+		return page(matched)
+	})
+}
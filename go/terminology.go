@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Termo preferido (estilo MedDRA PT) com sua classe de sistema/orgao (SOC)
+// e os sinonimos que levam a ele
+type adrTerm struct {
+	Term     string   `json:"term"`
+	SOC      string   `json:"soc"`
+	Synonyms []string `json:"synonyms"`
+}
+
+// ADR como o LLM devolveu (Verbatim) e codificada na terminologia.
+// Match: exact (termo preferido), synonym, fuzzy, merge (cluster-adrs) ou none
+type adrMention struct {
+	Verbatim string `json:"verbatim" bson:"verbatim"`
+	Term     string `json:"term,omitempty" bson:"term,omitempty"`
+	SOC      string `json:"soc,omitempty" bson:"soc,omitempty"`
+	Match    string `json:"match,omitempty" bson:"match,omitempty"`
+
+	// adverse_reaction, indication, withdrawal, beneficial ou lack_of_efficacy
+	Relation string `json:"relation,omitempty" bson:"relation,omitempty"`
+	// affirmed, negated, hypothetical ou uncertain; AssertionCue e o gatilho
+	// do lexico que rebaixou o status, quando foi ele
+	Assertion    string `json:"assertion,omitempty" bson:"assertion,omitempty"`
+	AssertionCue string `json:"assertion_cue,omitempty" bson:"assertion_cue,omitempty"`
+	// mild, moderate ou severe; criterios da ICH E2A atingidos e o DME (-dme) que a ADR e, se for
+	Severity    string        `json:"severity,omitempty" bson:"severity,omitempty"`
+	Seriousness []string      `json:"seriousness,omitempty" bson:"seriousness,omitempty"`
+	Serious     bool          `json:"serious,omitempty" bson:"serious,omitempty"`
+	DME         string        `json:"dme,omitempty" bson:"dme,omitempty"`
+	Evidence    *evidenceSpan `json:"evidence,omitempty" bson:"evidence,omitempty"`
+}
+
+// Termo codificado ou, sem codificacao, o texto original
+func (a adrMention) coded() string {
+	if a.Term != "" {
+		return a.Term
+	}
+	return a.Verbatim
+}
+
+func (a adrMention) String() string {
+	if a.Term != "" && !strings.EqualFold(a.Term, a.Verbatim) {
+		return fmt.Sprintf("%s (%s)", a.Verbatim, a.Term)
+	}
+	return a.Verbatim
+}
+
+type terminologyName struct {
+	folded    string
+	term      int
+	preferred bool
+}
+
+var (
+	adrTerms []adrTerm
+	// Termos preferidos e sinonimos sem acento e em minusculas
+	adrNames []terminologyName
+	adrIndex map[string]terminologyName
+)
+
+func loadTerminology(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var terms []adrTerm
+	if err := json.Unmarshal(data, &terms); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	adrTerms, adrNames, adrIndex = nil, nil, make(map[string]terminologyName)
+	for _, term := range terms {
+		if term.Term == "" {
+			continue
+		}
+		for i, name := range append([]string{term.Term}, term.Synonyms...) {
+			entry := terminologyName{folded: foldText(strings.TrimSpace(name)), term: len(adrTerms), preferred: i == 0}
+			if prev, ok := adrIndex[entry.folded]; ok {
+				if prev.term == entry.term {
+					continue
+				}
+				log.Printf("Terminology: %q is a name of both %s and %s, keeping %s", name, adrTerms[prev.term].Term, term.Term, adrTerms[prev.term].Term)
+				continue
+			}
+			adrIndex[entry.folded] = entry
+			adrNames = append(adrNames, entry)
+		}
+		adrTerms = append(adrTerms, term)
+	}
+	log.Printf("Terminology: %d preferred terms, %d names", len(adrTerms), len(adrNames))
+	return nil
+}
+
+// Distancia maxima aceita no match aproximado: ~1 edicao a cada 5 letras, ate 3.
+// Nomes curtos demais so casam exatamente
+func fuzzyThreshold(s string) int {
+	n := len([]rune(s)) / 5
+	if n > 3 {
+		n = 3
+	}
+	return n
+}
+
+// Prefixos de polaridade oposta: uma letra de diferenca inverte o sentido
+// (hypertension/hypotension, hiperglicemia/hipoglicemia)
+var polarityPrefixes = [][2]string{{"hyper", "hypo"}, {"hiper", "hipo"}}
+
+// O match aproximado so corrige erros de digitacao: mesmo numero de palavras,
+// na mesma ordem, cada uma dentro do limite dela e sem trocar hyper por hypo.
+// Assim "Increased libido" nao vira "Libido decreased"
+func fuzzyWordsMatch(a, b string) bool {
+	wa, wb := strings.Fields(a), strings.Fields(b)
+	if len(wa) != len(wb) {
+		return false
+	}
+	for i := range wa {
+		if wa[i] == wb[i] {
+			continue
+		}
+		if transpositionDistance(wa[i], wb[i]) > fuzzyThreshold(wa[i]) {
+			return false
+		}
+		for _, pair := range polarityPrefixes {
+			if (strings.HasPrefix(wa[i], pair[0]) && strings.HasPrefix(wb[i], pair[1])) ||
+				(strings.HasPrefix(wa[i], pair[1]) && strings.HasPrefix(wb[i], pair[0])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Codifica uma ADR: termo preferido, sinonimo ou o nome mais proximo por Levenshtein
+func codeADR(verbatim string) adrMention {
+	mention := adrMention{Verbatim: verbatim, Match: "none"}
+	folded := foldText(strings.TrimSpace(verbatim))
+	if folded == "" {
+		return mention
+	}
+
+	name, ok := adrIndex[folded]
+	if ok {
+		mention.Match = "synonym"
+		if name.preferred {
+			mention.Match = "exact"
+		}
+	} else {
+		best, bestDistance := -1, fuzzyThreshold(folded)+1
+		for i, candidate := range adrNames {
+			if !fuzzyWordsMatch(folded, candidate.folded) {
+				continue
+			}
+			if d := levenshtein(folded, candidate.folded); d < bestDistance {
+				best, bestDistance = i, d
+			}
+		}
+		if best < 0 {
+			return mention
+		}
+		name, mention.Match = adrNames[best], "fuzzy"
+	}
+
+	mention.Term = adrTerms[name.term].Term
+	mention.SOC = adrTerms[name.term].SOC
+	return mention
+}
+
+// Codifica as ADRs de cada medicamento ("X" = sem ADR fica como veio), seguindo
+// os merges aprovados do vocabulario
+func codeMedicationADRs(analysis []Medication) []Medication {
+	for i := range analysis {
+		for j, adr := range analysis[i].ADRs {
+			if adr.Verbatim == "X" {
+				continue
+			}
+			coded := codeADR(adr.Verbatim)
+			if canonical, ok := mergedTerm(coded.coded()); ok {
+				coded.Term, coded.Match = canonical, "merge"
+				if name, ok := adrIndex[foldText(canonical)]; ok {
+					coded.SOC = adrTerms[name.term].SOC
+				}
+			}
+			// So os campos da codificacao; o resto (evidencia etc.) continua
+			adr.Term, adr.SOC, adr.Match = coded.Term, coded.SOC, coded.Match
+			analysis[i].ADRs[j] = adr
+		}
+	}
+	return analysis
+}
+
+// Distancia de edicao (insercao, remocao, troca) entre duas strings, por runa
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Como levenshtein, mas trocar duas letras vizinhas de lugar conta uma edicao
+// ("weigth"/"weight"); usada palavra a palavra no match aproximado
+func transpositionDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dados de uso do medicamento citados no post. Os campos de texto vem do LLM
+// como escritos no post; DoseMg, DurationDays e OnsetDays sao a normalizacao,
+// quando possivel (sem unidade reconhecida ficam vazios)
+type therapyDetails struct {
+	Dose         string   `json:"dose,omitempty" bson:"dose,omitempty"`
+	DoseMg       *float64 `json:"dose_mg,omitempty" bson:"dose_mg,omitempty"`
+	Frequency    string   `json:"frequency,omitempty" bson:"frequency,omitempty"`
+	Route        string   `json:"route,omitempty" bson:"route,omitempty"`
+	Start        string   `json:"start,omitempty" bson:"start,omitempty"`
+	Stop         string   `json:"stop,omitempty" bson:"stop,omitempty"`
+	Duration     string   `json:"duration,omitempty" bson:"duration,omitempty"`
+	DurationDays *float64 `json:"duration_days,omitempty" bson:"duration_days,omitempty"`
+	Onset        string   `json:"onset,omitempty" bson:"onset,omitempty"`
+	OnsetDays    *float64 `json:"onset_days,omitempty" bson:"onset_days,omitempty"`
+}
+
+// Vias de administracao aceitas e seus sinonimos (sem acento, minusculas)
+var routeAliases = map[string]string{
+	"oral": "oral", "by mouth": "oral", "po": "oral", "via oral": "oral", "comprimido": "oral", "pill": "oral", "tablet": "oral",
+	"sublingual":  "sublingual",
+	"intravenous": "intravenous", "iv": "intravenous", "endovenosa": "intravenous", "intravenosa": "intravenous", "na veia": "intravenous",
+	"intramuscular": "intramuscular", "im": "intramuscular",
+	"subcutaneous": "subcutaneous", "subcutanea": "subcutaneous", "sc": "subcutaneous",
+	"topical": "topical", "topica": "topical", "topico": "topical", "cream": "topical", "pomada": "topical",
+	"transdermal": "transdermal", "patch": "transdermal", "adesivo": "transdermal", "parche": "transdermal",
+	"inhaled": "inhaled", "inhalation": "inhaled", "inalatoria": "inhaled", "inhalada": "inhaled", "bombinha": "inhaled",
+	"nasal": "nasal", "intranasal": "nasal", "spray nasal": "nasal",
+	"rectal": "rectal", "retal": "rectal",
+	"ophthalmic": "ophthalmic", "oftalmica": "ophthalmic", "colirio": "ophthalmic", "eye drops": "ophthalmic",
+}
+
+// Numeros por extenso comuns em pt/es/en
+var numberWords = map[string]float64{
+	"um": 1, "uma": 1, "un": 1, "uno": 1, "one": 1, "a": 1, "an": 1,
+	"dois": 2, "duas": 2, "dos": 2, "two": 2,
+	"tres": 3, "three": 3,
+	"quatro": 4, "cuatro": 4, "four": 4,
+	"cinco": 5, "five": 5,
+	"seis": 6, "six": 6,
+	"sete": 7, "siete": 7, "seven": 7,
+	"oito": 8, "ocho": 8, "eight": 8,
+	"nove": 9, "nueve": 9, "nine": 9,
+	"dez": 10, "diez": 10, "ten": 10,
+	"quinze": 15, "quince": 15, "fifteen": 15,
+	"vinte": 20, "veinte": 20, "twenty": 20,
+	"trinta": 30, "treinta": 30, "thirty": 30,
+	"meio": 0.5, "meia": 0.5, "medio": 0.5, "media": 0.5, "half": 0.5,
+}
+
+// Unidades de massa em mg
+var massUnits = map[string]float64{
+	"mg": 1, "miligrama": 1, "miligramas": 1, "miligramo": 1, "miligramos": 1, "milligram": 1, "milligrams": 1,
+	"g": 1000, "grama": 1000, "gramas": 1000, "gramo": 1000, "gramos": 1000, "gram": 1000, "grams": 1000,
+	"mcg": 0.001, "ug": 0.001, "µg": 0.001, "micrograma": 0.001, "microgramas": 0.001, "microgramo": 0.001, "microgramos": 0.001, "microgram": 0.001, "micrograms": 0.001,
+}
+
+// Unidades de tempo em dias
+var timeUnits = map[string]float64{
+	"h": 1.0 / 24, "hora": 1.0 / 24, "horas": 1.0 / 24, "hour": 1.0 / 24, "hours": 1.0 / 24,
+	"dia": 1, "dias": 1, "day": 1, "days": 1,
+	"semana": 7, "semanas": 7, "week": 7, "weeks": 7,
+	"mes": 30, "meses": 30, "month": 30, "months": 30,
+	"ano": 365, "anos": 365, "year": 365, "years": 365,
+}
+
+// Numeros e palavras; "20mg" vira "20", "mg"
+var quantityTokenRe = regexp.MustCompile(`\d+(?:[.,]\d+)?|[a-zµ]+`)
+
+// Primeira quantidade do texto ("20mg", "1,5 g", "duas semanas") com unidade
+// em units, convertida pelo fator da unidade
+func parseQuantity(text string, units map[string]float64) *float64 {
+	tokens := quantityTokenRe.FindAllString(foldText(text), -1)
+	for i := 0; i+1 < len(tokens); i++ {
+		factor, ok := units[tokens[i+1]]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.Replace(tokens[i], ",", ".", 1), 64)
+		if err != nil {
+			if n, ok = numberWords[tokens[i]]; !ok {
+				continue
+			}
+		}
+		value := math.Round(n*factor*1000) / 1000
+		return &value
+	}
+	return nil
+}
+
+func normalizeRoute(route string) string {
+	folded := strings.Join(strings.Fields(foldText(route)), " ")
+	if folded == "" {
+		return ""
+	}
+	if normalized, ok := routeAliases[folded]; ok {
+		return normalized
+	}
+	// Alias mais longo contido no texto ("spray nasal", "via oral")
+	best, bestAlias := folded, ""
+	for alias, normalized := range routeAliases {
+		if len(alias) > 3 && len(alias) > len(bestAlias) && strings.Contains(folded, alias) {
+			best, bestAlias = normalized, alias
+		}
+	}
+	return best
+}
+
+// Limpa a resposta do LLM ("X", "null", "unknown" viram vazio) e normaliza
+// dose para mg, duracao e tempo ate a reacao para dias e a via de administracao
+func normalizeTherapy(t *therapyDetails) *therapyDetails {
+	if t == nil {
+		return nil
+	}
+	for _, field := range []*string{&t.Dose, &t.Frequency, &t.Route, &t.Start, &t.Stop, &t.Duration, &t.Onset} {
+		*field = strings.TrimSpace(*field)
+		switch strings.ToLower(*field) {
+		case "x", "null", "none", "unknown", "n/a":
+			*field = ""
+		}
+	}
+	t.DoseMg = parseQuantity(t.Dose, massUnits)
+	t.DurationDays = parseQuantity(t.Duration, timeUnits)
+	t.OnsetDays = parseQuantity(t.Onset, timeUnits)
+	t.Route = normalizeRoute(t.Route)
+	if *t == (therapyDetails{}) {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Referencia a outro post (record.reply.root / record.reply.parent)
+type postRef struct {
+	URI string `json:"uri"`
+	CID string `json:"cid"`
+}
+
+type threadView struct {
+	Type   string      `json:"$type"`
+	Post   *Post       `json:"post"`
+	Parent *threadView `json:"parent"`
+}
+
+// Posts pai e raiz de uma resposta, usados como contexto no prompt
+type threadContext struct {
+	RootURI    string `bson:"root_uri"`
+	ParentURI  string `bson:"parent_uri"`
+	RootText   string `bson:"-"`
+	ParentText string `bson:"-"`
+}
+
+// getPostThread e publico no AppView, entao nao precisa do token da busca
+var publicClient = newRateLimitedClient()
+
+func fetchThreadContext(ctx context.Context, post Post) (*threadContext, error) {
+	reply := post.Record.Reply
+	if reply == nil {
+		return nil, nil
+	}
+	thread := &threadContext{RootURI: reply.Root.URI, ParentURI: reply.Parent.URI}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://public.api.bsky.app/xrpc/app.bsky.feed.getPostThread", nil)
+	if err != nil {
+		return thread, fmt.Errorf("failed to create request: %w", err)
+	}
+	q := url.Values{}
+	q.Add("uri", post.URI)
+	q.Add("depth", "0")
+	q.Add("parentHeight", "100")
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		return thread, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return thread, fmt.Errorf("getPostThread returned %s", resp.Status)
+	}
+
+	var result struct {
+		Thread threadView `json:"thread"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return thread, fmt.Errorf("failed to decode getPostThread response: %w", err)
+	}
+
+	// Sobe pela cadeia de pais; posts removidos/bloqueados vem sem "post"
+	for parent := result.Thread.Parent; parent != nil; parent = parent.Parent {
+		if parent.Post == nil {
+			continue
+		}
+		switch parent.Post.URI {
+		case thread.ParentURI:
+			thread.ParentText = parent.Post.Record.Text
+		case thread.RootURI:
+			thread.RootText = parent.Post.Record.Text
+		}
+	}
+	return thread, nil
+}
+
+// Trecho do prompt com a conversa em que o post esta inserido
+func threadPromptSection(thread *threadContext) string {
+	if thread == nil || (thread.RootText == "" && thread.ParentText == "") {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("The post is a reply. Use the conversation below ONLY AS CONTEXT to understand the post, extract only what the post itself says:\n")
+	if thread.RootText != "" && thread.RootURI != thread.ParentURI {
+		sb.WriteString(fmt.Sprintf("Root post: %s\n", thread.RootText))
+	}
+	if thread.ParentText != "" {
+		sb.WriteString(fmt.Sprintf("Parent post (the one being replied to): %s\n", thread.ParentText))
+	}
+	return sb.String()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	vocabularyApproved  = "approved"
+	vocabularyCandidate = "candidate"
+	vocabularyRejected  = "rejected"
+
+	// Termos por prompt e intervalo para reler os aprovados da colecao
+	adrReferenceLimit  = 40
+	vocabularyLifetime = 5 * time.Minute
+)
+
+// Termo do vocabulario de referencia das ADRs (colecao adr_vocabulary).
+// Os termos da terminologia entram aprovados; os que so o LLM devolveu entram
+// como candidatos ate um revisor aprovar ou rejeitar
+type vocabularyTerm struct {
+	Term      string         `bson:"term"`
+	Status    string         `bson:"status"`
+	SOC       string         `bson:"soc,omitempty"`
+	Frequency int            `bson:"frequency"`
+	Synonyms  []string       `bson:"synonyms"`
+	Drugs     map[string]int `bson:"drugs"` // chave do medicamento -> mencoes
+}
+
+var (
+	vocabularyMu     sync.Mutex
+	vocabulary       []vocabularyTerm // so os aprovados, mais frequentes primeiro
+	vocabularyLoaded time.Time
+	vocabularySeed   sync.Once
+	// Termo juntado por um merge aprovado -> termo canonico
+	vocabularyMerges map[string]string
+)
+
+// Chave do medicamento no mapa drugs: ID do dicionario ou o nome sem acento
+func drugKey(name string) string {
+	if entry, ok := normalizeDrug(name); ok {
+		return entry.ID
+	}
+	return strings.NewReplacer(".", "_", "$", "_").Replace(foldText(strings.TrimSpace(name)))
+}
+
+// Termos aprovados para o prompt: primeiro os mais citados para o medicamento,
+// depois os mais frequentes no geral
+func adrReference(drug string) string {
+	terms := approvedVocabulary()
+	key := drugKey(drug)
+
+	ranked := make([]vocabularyTerm, len(terms))
+	copy(ranked, terms)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Drugs[key] > ranked[j].Drugs[key]
+	})
+	if len(ranked) > adrReferenceLimit {
+		ranked = ranked[:adrReferenceLimit]
+	}
+
+	names := make([]string, 0, len(ranked))
+	for _, term := range ranked {
+		names = append(names, term.Term)
+	}
+	return strings.Join(names, ",")
+}
+
+// Aprovados da colecao, relidos a cada vocabularyLifetime; sem MongoDB (analyze
+// para stdout) valem os termos preferidos da terminologia
+func approvedVocabulary() []vocabularyTerm {
+	vocabularyMu.Lock()
+	defer vocabularyMu.Unlock()
+
+	if vocabularyColl == nil {
+		if vocabulary == nil {
+			for _, term := range adrTerms {
+				vocabulary = append(vocabulary, vocabularyTerm{Term: term.Term, Status: vocabularyApproved, SOC: term.SOC})
+			}
+		}
+		return vocabulary
+	}
+	if time.Since(vocabularyLoaded) < vocabularyLifetime {
+		return vocabulary
+	}
+
+	vocabularySeed.Do(seedVocabulary)
+	terms, err := loadVocabulary(vocabularyApproved)
+	if err != nil {
+		log.Printf("ADR vocabulary load error: %v", err)
+		return vocabulary
+	}
+	merges, err := loadVocabularyMerges()
+	if err != nil {
+		log.Printf("ADR merges load error: %v", err)
+	}
+	vocabulary, vocabularyMerges, vocabularyLoaded = terms, merges, time.Now()
+	return vocabulary
+}
+
+func loadVocabularyMerges() (map[string]string, error) {
+	cur, err := vocabularyColl.Find(context.TODO(), bson.M{"merged_into": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		Term       string `bson:"term"`
+		MergedInto string `bson:"merged_into"`
+	}
+	if err := cur.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+	merges := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		merges[doc.Term] = doc.MergedInto
+	}
+	return merges, nil
+}
+
+// Termo canonico de um termo ja juntado pelo cluster-adrs, para novas analises.
+// Segue a cadeia ate o fim (A -> B e depois B -> C da C para A)
+func mergedTerm(term string) (string, bool) {
+	vocabularyMu.Lock()
+	defer vocabularyMu.Unlock()
+	canonical, ok := vocabularyMerges[term]
+	if !ok {
+		return "", false
+	}
+	seen := map[string]bool{term: true}
+	for !seen[canonical] {
+		next, ok := vocabularyMerges[canonical]
+		if !ok {
+			break
+		}
+		seen[canonical] = true
+		canonical = next
+	}
+	return canonical, true
+}
+
+func loadVocabulary(status string) ([]vocabularyTerm, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "frequency", Value: -1}, {Key: "term", Value: 1}})
+	cur, err := vocabularyColl.Find(context.TODO(), bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var terms []vocabularyTerm
+	if err := cur.All(context.TODO(), &terms); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// Termos preferidos da terminologia entram aprovados; o status de quem ja
+// existe (uma rejeicao, por exemplo) nao muda
+func seedVocabulary() {
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	var models []mongo.WriteModel
+	for _, term := range adrTerms {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"term": term.Term}).
+			SetUpdate(bson.M{
+				"$set":      bson.M{"soc": term.SOC},
+				"$addToSet": bson.M{"synonyms": bson.M{"$each": term.Synonyms}},
+				"$setOnInsert": bson.M{
+					"status":     vocabularyApproved,
+					"frequency":  0,
+					"drugs":      bson.M{},
+					"first_seen": now,
+				},
+			}).
+			SetUpsert(true))
+	}
+	if len(models) == 0 {
+		return
+	}
+	if _, err := vocabularyColl.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Printf("ADR vocabulary seed error: %v", err)
+	}
+}
+
+// Frequencia por termo e por medicamento; termos novos entram como candidatos.
+// Reanalises (stored) nao contam de novo
+func vocabularyModels(analysis []Medication, stored bool) []mongo.WriteModel {
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	var models []mongo.WriteModel
+	for _, med := range analysis {
+		key := med.DrugID
+		if key == "" {
+			key = drugKey(med.Name)
+		}
+		for _, adr := range med.ADRs {
+			term := strings.TrimSpace(adr.coded())
+			// Indicacoes, outros efeitos e ADRs negadas/hipoteticas nao entram no vocabulario
+			if term == "" || adr.Verbatim == "X" || !adr.counted() {
+				continue
+			}
+
+			update := bson.M{
+				"$addToSet": bson.M{"synonyms": adr.Verbatim},
+				"$set":      bson.M{"last_seen": now},
+				"$setOnInsert": bson.M{
+					"status":     vocabularyCandidate,
+					"soc":        adr.SOC,
+					"first_seen": now,
+				},
+			}
+			if !stored {
+				inc := bson.M{"frequency": 1}
+				if key != "" {
+					inc["drugs."+key] = 1
+				}
+				update["$inc"] = inc
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"term": term}).
+				SetUpdate(update).
+				SetUpsert(true))
+		}
+	}
+	return models
+}
+
+// vocabulary [list [status]] | approve <termo>... | reject <termo>...
+func runVocabulary(args []string) {
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "list":
+		status := vocabularyCandidate
+		if len(args) > 0 {
+			status = args[0]
+		}
+		terms, err := loadVocabulary(status)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, term := range terms {
+			fmt.Printf("%6d  %-30s %s\n", term.Frequency, term.Term, strings.Join(term.Synonyms, ", "))
+		}
+		log.Printf("%d %s terms", len(terms), status)
+
+	case "approve", "reject":
+		status := vocabularyApproved
+		if action == "reject" {
+			status = vocabularyRejected
+		}
+		for _, term := range args {
+			result, err := vocabularyColl.UpdateOne(context.TODO(), bson.M{"term": term},
+				bson.M{"$set": bson.M{"status": status, "reviewed_at": primitive.NewDateTimeFromTime(time.Now().UTC())}})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if result.MatchedCount == 0 {
+				log.Printf("%s: not in the vocabulary", term)
+				continue
+			}
+			log.Printf("%s: %s", term, status)
+		}
+
+	default:
+		log.Fatalf("unknown vocabulary action %q (list, approve or reject)", action)
+	}
+}
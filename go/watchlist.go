@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Medicamento monitorado: nome generico canonico (a query gravada nos posts)
+// e todos os nomes pelos quais ele e buscado e reconhecido no texto
+type watchlistDrug struct {
+	Name         string              `json:"name" bson:"name"`
+	Brands       []string            `json:"brands" bson:"brands"`
+	Misspellings []string            `json:"misspellings" bson:"misspellings"`
+	Variants     map[string][]string `json:"variants" bson:"variants"` // idioma -> nomes
+	// Marcas que tambem sao palavras comuns (Concerta, Frontal, Pondera): buscadas
+	// como as outras, mas so casadas exatas pelo detector e nunca no Jetstream
+	Ambiguous []string `json:"ambiguous" bson:"ambiguous"`
+}
+
+var (
+	watchlist []watchlistDrug
+	// Termo (sem acento, minusculo) -> indice em watchlist
+	watchlistTerms map[string]int
+	// Nomes ambiguos (sem acento, minusculos)
+	ambiguousNames map[string]bool
+)
+
+// Nome canonico, variantes por idioma, marcas e erros comuns, sem repeticoes
+func (d watchlistDrug) terms() []string {
+	langs := make([]string, 0, len(d.Variants))
+	for lang := range d.Variants {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	all := []string{d.Name}
+	for _, lang := range langs {
+		all = append(all, d.Variants[lang]...)
+	}
+	all = append(all, d.Brands...)
+	all = append(all, d.Misspellings...)
+
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range all {
+		term = strings.TrimSpace(term)
+		if key := foldText(term); term != "" && !seen[key] {
+			seen[key] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Termos de busca: todos os nomes, inclusive os ambiguos; a busca da fonte ja
+// e pelo nome e o LLM confirma o medicamento
+func (d watchlistDrug) queries() []string {
+	queries := d.terms()
+	for _, name := range d.Ambiguous {
+		if name = strings.TrimSpace(name); name != "" && !slices.ContainsFunc(queries, func(q string) bool { return foldText(q) == foldText(name) }) {
+			queries = append(queries, name)
+		}
+	}
+	return queries
+}
+
+// Le a watchlist de um arquivo JSON ou, com "mongo", da colecao watchlist
+func loadWatchlist(source string) error {
+	var drugs []watchlistDrug
+	if source == "mongo" {
+		if mongoClient == nil {
+			initDB()
+		}
+		cur, err := mongoClient.Database("bluesky_data").Collection("watchlist").Find(context.TODO(), bson.M{})
+		if err != nil {
+			return err
+		}
+		if err := cur.All(context.TODO(), &drugs); err != nil {
+			return err
+		}
+	} else {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &drugs); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	watchlist, watchlistTerms, ambiguousNames = nil, make(map[string]int), make(map[string]bool)
+	for _, drug := range drugs {
+		drug.Name = strings.TrimSpace(drug.Name)
+		if drug.Name == "" {
+			continue
+		}
+		if i, ok := watchlistTerms[foldText(drug.Name)]; ok {
+			return fmt.Errorf("watchlist: %s is listed twice (also a name of %s)", drug.Name, watchlist[i].Name)
+		}
+		for _, term := range drug.terms() {
+			if i, ok := watchlistTerms[foldText(term)]; ok {
+				log.Printf("Watchlist: %q is a name of both %s and %s, keeping %s", term, watchlist[i].Name, drug.Name, watchlist[i].Name)
+				continue
+			}
+			watchlistTerms[foldText(term)] = len(watchlist)
+		}
+		for _, name := range drug.Ambiguous {
+			key := foldText(strings.TrimSpace(name))
+			if _, ok := watchlistTerms[key]; !ok && key != "" {
+				watchlistTerms[key] = len(watchlist)
+			}
+			ambiguousNames[key] = true
+		}
+		watchlist = append(watchlist, drug)
+	}
+	if len(watchlist) == 0 {
+		return fmt.Errorf("watchlist %s is empty", source)
+	}
+	log.Printf("Watchlist: %d drugs, %d search terms", len(watchlist), len(watchlistTerms))
+	return nil
+}
+
+// Nome que tambem e palavra comum, da lista ambiguous de algum medicamento
+func isAmbiguousName(name string) bool {
+	return ambiguousNames[foldText(strings.TrimSpace(name))]
+}
+
+// Medicamento da watchlist com esse nome (canonico, marca, variante, erro comum ou ambiguo)
+func findDrug(name string) (watchlistDrug, bool) {
+	i, ok := watchlistTerms[foldText(strings.TrimSpace(name))]
+	if !ok {
+		return watchlistDrug{}, false
+	}
+	return watchlist[i], true
+}
+
+// Nome canonico de um termo; termos fora da watchlist ficam como estao
+func canonicalName(term string) string {
+	if drug, ok := findDrug(term); ok {
+		return drug.Name
+	}
+	return strings.TrimSpace(term)
+}
+
+// Posts gravados antes da watchlist tem como query o termo buscado ("Fluoxetina",
+// "Rivotril"); passa para o nome canonico, que e o que o -incremental e as
+// consultas por medicamento procuram
+func normalizeStoredQueries() {
+	values, err := postsColl.Distinct(context.TODO(), "query", bson.M{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var updated int64
+	for _, value := range values {
+		query, ok := value.(string)
+		if !ok {
+			continue
+		}
+		canonical := canonicalName(query)
+		if canonical == query {
+			continue
+		}
+		result, err := postsColl.UpdateMany(context.TODO(), bson.M{"query": query}, bson.M{"$set": bson.M{"query": canonical}})
+		if err != nil {
+			log.Printf("Query update error for %s: %v", query, err)
+			continue
+		}
+		log.Printf("Query %q -> %q: %d posts", query, canonical, result.ModifiedCount)
+		updated += result.ModifiedCount
+	}
+	log.Printf("Normalized queries: %d posts updated", updated)
+}